@@ -0,0 +1,430 @@
+package krawler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	json "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	// ErrUnauthorized indicates the server rejects the credentials of a request
+	// with 401 Unauthorized. The credentials are renewed before the task is retried.
+	ErrUnauthorized = errors.New("request is unauthorized")
+
+	// ErrLoginFailed indicates a FormLogin gives up logging in
+	ErrLoginFailed = errors.New("login failed")
+)
+
+// Authenticator adds credentials to requests sent by HTTPDownloader. It is called
+// after every header is set and before the cache is looked up, so that cached
+// responses are not shared between different credentials. client can be used to
+// request tokens and shares the transport of the downloader. Returning a
+// PostponeError holds the task until the credentials are ready.
+type Authenticator interface {
+	Authenticate(request *http.Request, client *http.Client) error
+}
+
+// ResettableAuthenticator is implemented by authenticators whose credentials can
+// expire. Reset is called when a request is answered with 401 Unauthorized, so that
+// the credentials are renewed for the next request.
+type ResettableAuthenticator interface {
+	Authenticator
+	Reset()
+}
+
+// authConfirmer is implemented by authenticators that want to know their
+// credentials are accepted. confirm is called when a request authenticated by them
+// is not answered with 401 Unauthorized.
+type authConfirmer interface {
+	confirm()
+}
+
+// AuthenticatorFunc implements Authenticator with a function.
+type AuthenticatorFunc func(request *http.Request, client *http.Client) error
+
+// Authenticate implements Authenticator#Authenticate
+func (f AuthenticatorFunc) Authenticate(request *http.Request, client *http.Client) error {
+	return f(request, client)
+}
+
+// NewBasicAuth creates an authenticator sending static HTTP basic credentials.
+func NewBasicAuth(username, password string) Authenticator {
+	return AuthenticatorFunc(func(request *http.Request, client *http.Client) error {
+		request.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// NewBearerAuth creates an authenticator sending a static bearer token.
+func NewBearerAuth(token string) Authenticator {
+	return AuthenticatorFunc(func(request *http.Request, client *http.Client) error {
+		request.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// OAuth2Config defines the structure of OAuth2Config
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// EndpointParams are additional parameters posted to the token endpoint.
+	EndpointParams url.Values
+
+	// RefreshBefore is how long before its expiry a token is refreshed. Default to
+	// one minute.
+	RefreshBefore time.Duration
+}
+
+// OAuth2ClientCredentials sends bearer tokens obtained with the OAuth2 client
+// credentials grant, and refreshes the token before it expires. Requests keep
+// using the old token while it is being refreshed.
+type OAuth2ClientCredentials struct {
+	config OAuth2Config
+
+	mutex       sync.Mutex
+	token       string
+	tokenType   string
+	refreshTime time.Time
+	refreshing  chan struct{}
+}
+
+// NewOAuth2ClientCredentials creates an OAuth2 client credentials authenticator.
+func NewOAuth2ClientCredentials(config OAuth2Config) *OAuth2ClientCredentials {
+	if config.RefreshBefore <= 0 {
+		config.RefreshBefore = time.Minute
+	}
+	return &OAuth2ClientCredentials{config: config}
+}
+
+// Authenticate implements Authenticator#Authenticate
+func (a *OAuth2ClientCredentials) Authenticate(request *http.Request, client *http.Client) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for a.token == "" || (!a.refreshTime.IsZero() && time.Now().After(a.refreshTime)) {
+		if a.refreshing == nil {
+			if err := a.refresh(client); err != nil && a.token == "" {
+				return err
+			} else if err != nil {
+				log.Warnf("Fail to refresh OAuth2 token from %s, keep using the old one, reason: %v", a.config.TokenURL, err)
+			}
+			break
+		}
+		if a.token != "" {
+			// the token has not expired yet
+			break
+		}
+
+		// wait for the running refresh without holding the lock
+		refreshing := a.refreshing
+		a.mutex.Unlock()
+		<-refreshing
+		a.mutex.Lock()
+	}
+
+	request.Header.Set("Authorization", a.tokenType+" "+a.token)
+	return nil
+}
+
+// refresh requests a new token. It is called with the lock held, and releases the
+// lock while the token is being requested.
+func (a *OAuth2ClientCredentials) refresh(client *http.Client) error {
+	refreshing := make(chan struct{})
+	a.refreshing = refreshing
+	a.mutex.Unlock()
+
+	token, tokenType, refreshTime, err := a.requestToken(client)
+
+	a.mutex.Lock()
+	a.refreshing = nil
+	close(refreshing)
+	if err != nil {
+		return err
+	}
+
+	a.token = token
+	a.tokenType = tokenType
+	a.refreshTime = refreshTime
+	return nil
+}
+
+// requestToken requests a token from the token endpoint, and returns the token,
+// its type and the time to refresh it.
+func (a *OAuth2ClientCredentials) requestToken(client *http.Client) (string, string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.config.Scopes, " "))
+	}
+	for key, values := range a.config.EndpointParams {
+		form[key] = values
+	}
+
+	request, err := http.NewRequest(http.MethodPost, a.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("create token request failed, reason: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("request token failed, reason: %v", err)
+	}
+	defer response.Body.Close()
+
+	raw, err := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("read token failed, reason: %v", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", "", time.Time{}, fmt.Errorf("request token failed, status code %d: %s", response.StatusCode, raw)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(raw, &token); err != nil || token.AccessToken == "" {
+		return "", "", time.Time{}, fmt.Errorf("invalid token response: %s", raw)
+	}
+
+	tokenType := "Bearer"
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		tokenType = token.TokenType
+	}
+	var refreshTime time.Time
+	if token.ExpiresIn > 0 {
+		refreshTime = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - a.config.RefreshBefore)
+	}
+	log.Infof("Obtained OAuth2 token from %s, expires in %ds", a.config.TokenURL, token.ExpiresIn)
+	return token.AccessToken, tokenType, refreshTime, nil
+}
+
+// Reset implements ResettableAuthenticator#Reset
+func (a *OAuth2ClientCredentials) Reset() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.token = ""
+}
+
+// FormLoginConfig defines the structure of FormLoginConfig
+type FormLoginConfig struct {
+	// URL is where the login form is posted to.
+	URL  string
+	Form url.Values
+
+	// TokenPage is fetched before posting the form if not empty, and the value of
+	// the input named TokenField in it is posted along, like a CSRF token.
+	TokenPage  string
+	TokenField string
+
+	// Success tells whether the login succeeds. Default to a status code below 400.
+	Success func(response *http.Response, body []byte) bool
+
+	// RetryInterval is the time between two login attempts, and MaxAttempts is the
+	// number of attempts before giving up. Default to 10 seconds and 3 attempts.
+	RetryInterval time.Duration
+	MaxAttempts   int
+}
+
+// FormLogin logs in by posting a form, and sends the cookies of the logged-in
+// session with requests. Tasks are postponed while the login is running, and the
+// login runs again once a request is answered with 401 Unauthorized. A session
+// rejected before any request is accepted with it counts as a failed attempt.
+type FormLogin struct {
+	config FormLoginConfig
+	jar    http.CookieJar
+
+	mutex     sync.Mutex
+	loggedIn  bool
+	verified  bool
+	running   bool
+	attempts  int
+	failedAt  time.Time
+	lastError error
+}
+
+// NewFormLogin creates a form login authenticator.
+func NewFormLogin(config FormLoginConfig) *FormLogin {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 10 * time.Second
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 3
+	}
+	if config.Success == nil {
+		config.Success = func(response *http.Response, body []byte) bool {
+			return response.StatusCode < 400
+		}
+	}
+
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &FormLogin{config: config, jar: jar}
+}
+
+// Authenticate implements Authenticator#Authenticate
+func (a *FormLogin) Authenticate(request *http.Request, client *http.Client) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.loggedIn {
+		for _, cookie := range a.jar.Cookies(request.URL) {
+			request.AddCookie(cookie)
+		}
+		return nil
+	}
+
+	if a.attempts >= a.config.MaxAttempts {
+		return fmt.Errorf("%v, reason: %v", ErrLoginFailed, a.lastError)
+	}
+
+	if !a.running && time.Since(a.failedAt) >= a.config.RetryInterval {
+		a.running = true
+		go a.login(client)
+	}
+	return &PostponeError{Reason: fmt.Sprintf("waiting for login to %s", a.config.URL), Delay: a.config.RetryInterval}
+}
+
+func (a *FormLogin) login(client *http.Client) {
+	loginClient := *client
+	loginClient.Jar = a.jar
+
+	err := a.postForm(&loginClient)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.running = false
+	if err != nil {
+		a.attempts++
+		a.failedAt = time.Now()
+		a.lastError = err
+		log.Errorf("Fail to login to %s (attempt %d/%d), reason: %v", a.config.URL, a.attempts, a.config.MaxAttempts, err)
+		return
+	}
+
+	// attempts are only cleared once the session is accepted by a request
+	a.loggedIn = true
+	a.verified = false
+	log.Infof("Logged in to %s", a.config.URL)
+}
+
+func (a *FormLogin) postForm(client *http.Client) error {
+	form := url.Values{}
+	for key, values := range a.config.Form {
+		form[key] = values
+	}
+
+	if a.config.TokenPage != "" {
+		token, err := a.fetchToken(client)
+		if err != nil {
+			return err
+		}
+		form.Set(a.config.TokenField, token)
+	}
+
+	response, err := client.PostForm(a.config.URL, form)
+	if err != nil {
+		return fmt.Errorf("post login form failed, reason: %v", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("read login response failed, reason: %v", err)
+	}
+	if !a.config.Success(response, body) {
+		return fmt.Errorf("login is rejected with status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (a *FormLogin) fetchToken(client *http.Client) (string, error) {
+	response, err := client.Get(a.config.TokenPage)
+	if err != nil {
+		return "", fmt.Errorf("fetch login page failed, reason: %v", err)
+	}
+	defer response.Body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("parse login page failed, reason: %v", err)
+	}
+
+	token, exists := doc.Find(fmt.Sprintf("input[name=%q]", a.config.TokenField)).Attr("value")
+	if !exists {
+		return "", fmt.Errorf("no input named %s in the login page", a.config.TokenField)
+	}
+	return token, nil
+}
+
+// Reset implements ResettableAuthenticator#Reset
+func (a *FormLogin) Reset() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if !a.loggedIn {
+		return
+	}
+	a.loggedIn = false
+
+	if !a.verified {
+		a.attempts++
+		a.failedAt = time.Now()
+		a.lastError = errors.New("session is rejected right after login")
+		log.Errorf("Session of %s is rejected right after login (attempt %d/%d)", a.config.URL, a.attempts, a.config.MaxAttempts)
+		return
+	}
+	a.failedAt = time.Time{}
+	log.Warnf("Session of %s is rejected, login again", a.config.URL)
+}
+
+// confirm implements authConfirmer#confirm
+func (a *FormLogin) confirm() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.loggedIn && !a.verified {
+		a.verified = true
+		a.attempts = 0
+	}
+}
+
+// authenticator returns the authenticator of a task, which is the one of its
+// processor if any, otherwise the one of the host or its nearest parent domain.
+func (d *HTTPDownloader) authenticator(host string, task *Task) Authenticator {
+	if auth, exists := d.processorAuths[task.ProcessorName]; exists {
+		return auth
+	}
+
+	return hostAuthenticator(d.hostAuths, host)
+}
+
+// hostAuthenticator returns the authenticator of the host or its nearest parent
+// domain.
+func hostAuthenticator(auths map[string]Authenticator, host string) Authenticator {
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i := range labels {
+		if auth, exists := auths[strings.Join(labels[i:], ".")]; exists {
+			return auth
+		}
+	}
+	return nil
+}
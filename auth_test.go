@@ -0,0 +1,227 @@
+package krawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPDownloaderCacheCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	downloader := NewHTTPDownloader(GetDefaultConfig(),
+		WithCache(NewHTTPCache(newMemoryCacheStorage())),
+		WithProcessorAuthenticator("a", NewBearerAuth("a")),
+		WithProcessorAuthenticator("b", NewBearerAuth("b")),
+	)
+	defer downloader.Shutdown()
+
+	tests := []struct {
+		processorName string
+		content       string
+		cacheHit      bool
+	}{
+		{"a", "Bearer a", false},
+		{"b", "Bearer b", false},
+		{"a", "Bearer a", true},
+		{"", "", false},
+		{"b", "Bearer b", true},
+	}
+
+	for i, test := range tests {
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: server.URL, Method: http.MethodGet, ProcessorName: test.processorName}, ch)
+		result := <-ch
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if string(result.Content) != test.content || result.CacheHit != test.cacheHit {
+			t.Errorf("step %d: expect %q cache hit %v, got %q %v", i, test.content, test.cacheHit, result.Content, result.CacheHit)
+		}
+	}
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	var issued int32
+	var failing int32
+	delay := 50 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if id, secret, _ := r.BasicAuth(); id != "id" || secret != "secret" || r.FormValue("scope") != "read write" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "t%d", "token_type": "bearer", "expires_in": 3600}`, atomic.AddInt32(&issued, 1))
+	}))
+	defer server.Close()
+
+	auth := NewOAuth2ClientCredentials(OAuth2Config{
+		TokenURL:     server.URL,
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	})
+	authenticate := func() (string, error) {
+		request, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		err := auth.Authenticate(request, http.DefaultClient)
+		return request.Header.Get("Authorization"), err
+	}
+	expire := func() {
+		auth.mutex.Lock()
+		auth.refreshTime = time.Now().Add(-time.Second)
+		auth.mutex.Unlock()
+	}
+
+	// requests without a token wait for a single refresh
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = authenticate()
+		}(i)
+	}
+	wg.Wait()
+	if strings.Join(tokens, ",") != strings.Repeat("Bearer t1,", 4)+"Bearer t1" || atomic.LoadInt32(&issued) != 1 {
+		t.Fatalf("expect a single token for every request, got %v from %d tokens", tokens, issued)
+	}
+
+	// requests keep using the old token while it is being refreshed
+	expire()
+	refreshed := make(chan string)
+	go func() {
+		token, _ := authenticate()
+		refreshed <- token
+	}()
+	time.Sleep(delay / 5)
+	start := time.Now()
+	if token, err := authenticate(); token != "Bearer t1" || err != nil || time.Since(start) >= delay/2 {
+		t.Errorf("expect the old token without waiting, got %q and %v after %v", token, err, time.Since(start))
+	}
+	if token := <-refreshed; token != "Bearer t2" {
+		t.Errorf("expect the refreshed token, got %q", token)
+	}
+
+	steps := []struct {
+		action  string
+		failing int32
+		token   string
+		fail    bool
+	}{
+		{"", 0, "Bearer t2", false},
+		{"reset", 0, "Bearer t3", false},
+		{"expire", 1, "Bearer t3", false},
+		{"reset", 1, "", true},
+		{"", 0, "Bearer t4", false},
+	}
+
+	for i, step := range steps {
+		atomic.StoreInt32(&failing, step.failing)
+		switch step.action {
+		case "reset":
+			auth.Reset()
+		case "expire":
+			expire()
+		}
+		if token, err := authenticate(); token != step.token || (err != nil) != step.fail {
+			t.Errorf("step %d: expect %q and failure %v, got %q and %v", i, step.token, step.fail, token, err)
+		}
+	}
+}
+
+func TestFormLogin(t *testing.T) {
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/form":
+			w.Write([]byte(`<form><input name="csrf" value="token"></form>`))
+		case "/login":
+			if r.FormValue("csrf") != "token" || r.FormValue("user") != "krawler" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			n := atomic.AddInt32(&logins, 1)
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: fmt.Sprint(n), Path: "/"})
+		}
+	}))
+	defer server.Close()
+
+	newLogin := func(user string) *FormLogin {
+		return NewFormLogin(FormLoginConfig{
+			URL:           server.URL + "/login",
+			Form:          map[string][]string{"user": {user}},
+			TokenPage:     server.URL + "/form",
+			TokenField:    "csrf",
+			RetryInterval: time.Millisecond,
+			MaxAttempts:   2,
+		})
+	}
+	// authenticate waits for the login and returns the cookie sent with a request
+	authenticate := func(auth *FormLogin) (string, error) {
+		for i := 0; i < 100; i++ {
+			request, _ := http.NewRequest(http.MethodGet, server.URL+"/page", nil)
+			err := auth.Authenticate(request, http.DefaultClient)
+			if _, postponed := err.(*PostponeError); !postponed {
+				return request.Header.Get("Cookie"), err
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("expect the login to finish")
+		return "", nil
+	}
+
+	tests := []struct {
+		user    string
+		actions []string
+		cookie  string
+		fail    string
+	}{
+		{"krawler", nil, "session=1", ""},
+		{"nobody", nil, "", "login is rejected with status code 403"},
+		// a session that is never accepted counts as a failed attempt
+		{"krawler", []string{"reset", "reset"}, "", "session is rejected right after login"},
+		{"krawler", []string{"reset", "confirm", "reset"}, "session=3", ""},
+		{"krawler", []string{"confirm", "reset", "confirm", "reset", "confirm", "reset"}, "session=4", ""},
+	}
+
+	for i, test := range tests {
+		atomic.StoreInt32(&logins, 0)
+		auth := newLogin(test.user)
+
+		for _, action := range test.actions {
+			if _, err := authenticate(auth); err != nil {
+				t.Fatalf("test %d: expect to login, got %v", i, err)
+			}
+			if action == "reset" {
+				auth.Reset()
+			} else {
+				auth.confirm()
+			}
+		}
+
+		cookie, err := authenticate(auth)
+		if test.fail != "" {
+			if err == nil || !strings.Contains(err.Error(), ErrLoginFailed.Error()) || !strings.Contains(err.Error(), test.fail) {
+				t.Errorf("test %d: expect login failure %q, got %v", i, test.fail, err)
+			}
+			continue
+		}
+		if err != nil || cookie != test.cookie {
+			t.Errorf("test %d: expect cookie %q, got %q and %v", i, test.cookie, cookie, err)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package krawler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	json "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// CachedResponse is a response stored in a CacheStorage.
+type CachedResponse struct {
+	URL        string
+	StatusCode int
+	Headers    http.Header
+	Content    []byte
+	StoredAt   time.Time
+
+	// VaryHeaders are the values of the request header fields named by the Vary
+	// header of the response.
+	VaryHeaders http.Header
+}
+
+// CacheStorage stores cached responses.
+type CacheStorage interface {
+	// Get returns the response stored under key, or nil if there is none.
+	Get(key string) (*CachedResponse, error)
+
+	// Set stores a response under key.
+	Set(key string, response *CachedResponse) error
+}
+
+// HTTPCache caches responses of GET requests following the Cache-Control rules
+// of a private cache. Fresh responses are served without a request. Stale ones are
+// revalidated with If-None-Match and If-Modified-Since. Responses are not shared
+// between sessions, nor between requests with different credentials or different
+// values of the header fields the response varies on.
+type HTTPCache struct {
+	storage CacheStorage
+}
+
+// NewHTTPCache creates a HTTP cache upon storage.
+func NewHTTPCache(storage CacheStorage) *HTTPCache {
+	return &HTTPCache{storage: storage}
+}
+
+// cacheKey returns the key of a request of a task in the storage, or an empty
+// string if the request cannot be cached.
+func cacheKey(request *http.Request, task *Task) string {
+	if request.Method != http.MethodGet || cacheControl(request.Header)["no-store"] != "" {
+		return ""
+	}
+
+	key := request.Method + " " + request.URL.String()
+	if task.Session != "" {
+		key += " session=" + task.Session
+	}
+	credentials := request.Header.Get("Authorization") + "\n" + strings.Join(request.Header["Cookie"], "; ")
+	if credentials != "\n" {
+		key += " credentials=" + hashKey(credentials)
+	}
+	return key
+}
+
+// varyFields returns the request header fields listed in the Vary header.
+func varyFields(header http.Header) []string {
+	var fields []string
+	for _, field := range header["Vary"] {
+		for _, name := range strings.Split(field, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				fields = append(fields, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return fields
+}
+
+// matches tells whether the request has the same values of the header fields the
+// cached response varies on.
+func (r *CachedResponse) matches(request *http.Request) bool {
+	for _, field := range varyFields(r.Headers) {
+		if strings.Join(request.Header[field], ",") != strings.Join(r.VaryHeaders[field], ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheControl parses the Cache-Control header. Directives without a value are
+// mapped to themselves.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, field := range header["Cache-Control"] {
+		for _, directive := range strings.Split(field, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if directive == "" {
+				continue
+			}
+			if i := strings.Index(directive, "="); i >= 0 {
+				directives[directive[:i]] = strings.Trim(directive[i+1:], `"`)
+			} else {
+				directives[directive] = directive
+			}
+		}
+	}
+	return directives
+}
+
+// lookup returns the key of a request of a task, its cached response and whether
+// the response is fresh. If it is stale, conditional headers are added to the
+// request. The key is empty if the request cannot be cached.
+func (c *HTTPCache) lookup(request *http.Request, task *Task) (string, *CachedResponse, bool) {
+	key := cacheKey(request, task)
+	if key == "" {
+		return "", nil, false
+	}
+
+	cached, err := c.storage.Get(key)
+	if err != nil {
+		log.Errorf("Fail to read cache of %s, reason: %v", key, err)
+		return key, nil, false
+	}
+	if cached == nil || !cached.matches(request) {
+		return key, nil, false
+	}
+
+	if cacheControl(request.Header)["no-cache"] == "" && cached.fresh(time.Now()) {
+		return key, cached, true
+	}
+
+	if etag := cached.Headers.Get("ETag"); etag != "" && request.Header.Get("If-None-Match") == "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	if modified := cached.Headers.Get("Last-Modified"); modified != "" && request.Header.Get("If-Modified-Since") == "" {
+		request.Header.Set("If-Modified-Since", modified)
+	}
+	return key, cached, false
+}
+
+// fresh tells whether a cached response can be used without revalidation.
+func (r *CachedResponse) fresh(now time.Time) bool {
+	directives := cacheControl(r.Headers)
+	if directives["no-cache"] != "" {
+		return false
+	}
+
+	age := now.Sub(r.StoredAt)
+	if seconds, err := strconv.Atoi(r.Headers.Get("Age")); err == nil {
+		age += time.Duration(seconds) * time.Second
+	}
+
+	var lifetime time.Duration
+	date, dateErr := http.ParseTime(r.Headers.Get("Date"))
+	if dateErr != nil {
+		date = r.StoredAt
+	}
+	if seconds, err := strconv.Atoi(directives["max-age"]); err == nil {
+		lifetime = time.Duration(seconds) * time.Second
+	} else if expires, err := http.ParseTime(r.Headers.Get("Expires")); err == nil {
+		lifetime = expires.Sub(date)
+	} else if modified, err := http.ParseTime(r.Headers.Get("Last-Modified")); err == nil {
+		// heuristic freshness suggested by RFC 7234
+		lifetime = date.Sub(modified) / 10
+	}
+
+	return age < lifetime
+}
+
+// store caches a downloaded result under key if the response allows it. Bodies
+// streamed into files are too large to be kept in the storage and not cached.
+func (c *HTTPCache) store(key string, request *http.Request, result *DownloadResult) {
+	if key == "" || result.StatusCode != http.StatusOK || result.BodyPath != "" || result.Truncated {
+		return
+	}
+	if cacheControl(result.Headers)["no-store"] != "" {
+		return
+	}
+
+	varyHeaders := make(http.Header)
+	for _, field := range varyFields(result.Headers) {
+		if field == "*" {
+			return
+		}
+		varyHeaders[field] = request.Header[field]
+	}
+
+	err := c.storage.Set(key, &CachedResponse{
+		URL:         result.URL,
+		StatusCode:  result.StatusCode,
+		Headers:     result.Headers,
+		Content:     result.Content,
+		StoredAt:    time.Now(),
+		VaryHeaders: varyHeaders,
+	})
+	if err != nil {
+		log.Errorf("Fail to write cache of %s, reason: %v", key, err)
+	}
+}
+
+// revalidate refreshes a cached response under key with the headers of a 304
+// response.
+func (c *HTTPCache) revalidate(key string, cached *CachedResponse, header http.Header) {
+	for field, values := range header {
+		cached.Headers[field] = values
+	}
+	cached.StoredAt = time.Now()
+
+	if err := c.storage.Set(key, cached); err != nil {
+		log.Errorf("Fail to write cache of %s, reason: %v", key, err)
+	}
+}
+
+// fill fills a result with a cached response.
+func (r *CachedResponse) fill(result *DownloadResult) {
+	result.URL = r.URL
+	result.StatusCode = r.StatusCode
+	result.Headers = r.Headers
+	result.Content = r.Content
+	result.CacheHit = true
+}
+
+// hashKey turns a cache key into a fixed-length name.
+func hashKey(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// FileCacheStorage stores every cached response in a JSON file under a directory.
+type FileCacheStorage struct {
+	dir string
+}
+
+// NewFileCacheStorage creates a cache storage that saves files into dir.
+func NewFileCacheStorage(dir string) (*FileCacheStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create cache directory %s failed, reason: %v", dir, err)
+	}
+	return &FileCacheStorage{dir: dir}, nil
+}
+
+func (s *FileCacheStorage) path(key string) string {
+	name := hashKey(key)
+	return filepath.Join(s.dir, name[:2], name+".json")
+}
+
+// Get reads a cached response from its file.
+func (s *FileCacheStorage) Get(key string) (*CachedResponse, error) {
+	raw, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	response := new(CachedResponse)
+	if err := json.Unmarshal(raw, response); err != nil {
+		return nil, fmt.Errorf("invalid cache file %s, reason: %v", s.path(key), err)
+	}
+	return response, nil
+}
+
+// Set writes a cached response into its file.
+func (s *FileCacheStorage) Set(key string, response *CachedResponse) error {
+	raw, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that neither a crash nor a concurrent
+	// writer of the same key leaves a broken file
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(raw)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// RedisCacheStorage stores cached responses in redis.
+type RedisCacheStorage struct {
+	redis     *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+}
+
+// NewRedisCacheStorage creates a cache storage in redis. Responses expire after ttl
+// unless it is zero.
+func NewRedisCacheStorage(id string, redisOptions *redis.Options, ttl time.Duration) *RedisCacheStorage {
+	return &RedisCacheStorage{
+		redis:     redis.NewClient(redisOptions),
+		keyPrefix: fmt.Sprintf("{krawler:%s}:cache:", id),
+		ttl:       ttl,
+	}
+}
+
+// Get reads a cached response from redis.
+func (s *RedisCacheStorage) Get(key string) (*CachedResponse, error) {
+	raw, err := s.redis.Get(s.keyPrefix + hashKey(key)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("fail to read cache, reason: %v", err)
+	}
+
+	response := new(CachedResponse)
+	if err := json.Unmarshal(raw, response); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal a cached response, reason: %v", err)
+	}
+	return response, nil
+}
+
+// Set writes a cached response into redis.
+func (s *RedisCacheStorage) Set(key string, response *CachedResponse) error {
+	raw, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("fail to marshal a cached response, reason: %v", err)
+	}
+
+	if err := s.redis.Set(s.keyPrefix+hashKey(key), raw, s.ttl).Err(); err != nil {
+		return fmt.Errorf("fail to write cache, reason: %v", err)
+	}
+	return nil
+}
+
+// Close closes the redis connection.
+func (s *RedisCacheStorage) Close() error {
+	return s.redis.Close()
+}
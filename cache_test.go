@@ -0,0 +1,106 @@
+package krawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// memoryCacheStorage keeps cached responses in a map.
+type memoryCacheStorage struct {
+	mutex     sync.Mutex
+	responses map[string]*CachedResponse
+}
+
+func newMemoryCacheStorage() *memoryCacheStorage {
+	return &memoryCacheStorage{responses: make(map[string]*CachedResponse)}
+}
+
+func (s *memoryCacheStorage) Get(key string) (*CachedResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.responses[key], nil
+}
+
+func (s *memoryCacheStorage) Set(key string, response *CachedResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.responses[key] = response
+	return nil
+}
+
+func TestHTTPCacheStore(t *testing.T) {
+	request, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	cacheable := http.Header{"Cache-Control": {"max-age=60"}}
+
+	tests := []struct {
+		key    string
+		result *DownloadResult
+		stored bool
+	}{
+		{"k", &DownloadResult{StatusCode: 200, Headers: cacheable, Content: []byte("a")}, true},
+		{"", &DownloadResult{StatusCode: 200, Headers: cacheable, Content: []byte("a")}, false},
+		{"k", &DownloadResult{StatusCode: 404, Headers: cacheable}, false},
+		{"k", &DownloadResult{StatusCode: 200, Headers: cacheable, BodyPath: "/tmp/body"}, false},
+		{"k", &DownloadResult{StatusCode: 200, Headers: cacheable, Content: []byte("a"), Truncated: true}, false},
+		{"k", &DownloadResult{StatusCode: 200, Headers: http.Header{"Cache-Control": {"no-store"}}}, false},
+		{"k", &DownloadResult{StatusCode: 200, Headers: http.Header{"Vary": {"*"}}}, false},
+	}
+
+	for i, test := range tests {
+		storage := newMemoryCacheStorage()
+		NewHTTPCache(storage).store(test.key, request, test.result)
+		if stored := len(storage.responses) == 1; stored != test.stored {
+			t.Errorf("result %d: expect stored %v, got %v", i, test.stored, stored)
+		}
+	}
+}
+
+func TestHTTPDownloaderCache(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/large" {
+			w.Write([]byte(strings.Repeat("x", 1024)))
+			return
+		}
+		w.Write([]byte("small"))
+	}))
+	defer server.Close()
+
+	config := GetDefaultConfig()
+	config.Response.StreamThreshold = 512
+	downloader := NewHTTPDownloader(config, WithCache(NewHTTPCache(newMemoryCacheStorage())))
+	defer downloader.Shutdown()
+
+	tests := []struct {
+		path     string
+		cacheHit bool
+		requests int32
+	}{
+		{"/small", false, 1},
+		{"/small", true, 1},
+		{"/large", false, 2},
+		{"/large", false, 3},
+	}
+
+	for i, test := range tests {
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: server.URL + test.path, Method: http.MethodGet}, ch)
+		result := <-ch
+		result.Release()
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if result.CacheHit != test.cacheHit {
+			t.Errorf("step %d: expect cache hit %v, got %v", i, test.cacheHit, result.CacheHit)
+		}
+		if n := atomic.LoadInt32(&requests); n != test.requests {
+			t.Errorf("step %d: expect %d requests, got %d", i, test.requests, n)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package krawler
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// sniffLength is the number of leading bytes inspected to detect the charset.
+const sniffLength = 1024
+
+// detectCharset detects the charset of a body from a BOM, the Content-Type header,
+// a <meta charset> declaration or the bytes themselves, and returns its canonical
+// name. UTF-8 is assumed if the body is valid UTF-8 and nothing is declared.
+func detectCharset(head []byte, contentType string) string {
+	_, name, certain := charset.DetermineEncoding(head, contentType)
+	// windows-1252 is the fallback of DetermineEncoding when nothing is found,
+	// which is rarely right for an ASCII head
+	if !certain && name == "windows-1252" && !declaresCharset(head) && validUTF8Prefix(head) {
+		return "utf-8"
+	}
+	return name
+}
+
+// declaresCharset tells whether the head may have a charset declaration, which
+// DetermineEncoding trusts.
+func declaresCharset(head []byte) bool {
+	return bytes.Contains(bytes.ToLower(head), []byte("charset"))
+}
+
+// validUTF8Prefix reports whether b is valid UTF-8, ignoring a rune that may have
+// been cut at the end.
+func validUTF8Prefix(b []byte) bool {
+	for i := len(b) - 1; i >= 0 && i > len(b)-utf8.UTFMax; i-- {
+		if b[i] < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(b[i]) {
+			b = b[:i]
+			break
+		}
+	}
+	return utf8.Valid(b)
+}
+
+// detectResultCharset detects the charset of a downloaded result.
+func detectResultCharset(result *DownloadResult) {
+	if head, err := headOf(result, sniffLength); err == nil {
+		result.Charset = detectCharset(head, result.Headers.Get("Content-Type"))
+	}
+}
+
+// headOf returns the first length bytes of the body of a result.
+func headOf(result *DownloadResult, length int) ([]byte, error) {
+	if result.BodyPath == "" {
+		if len(result.Content) > length {
+			return result.Content[:length], nil
+		}
+		return result.Content, nil
+	}
+
+	body, err := result.Body()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(io.LimitReader(body, int64(length)))
+}
+
+// textReader returns a reader of the body decoded into UTF-8.
+func (r *DownloadResult) textReader() (io.ReadCloser, error) {
+	if r.Charset == "" {
+		head, err := headOf(r, sniffLength)
+		if err != nil {
+			return nil, err
+		}
+		r.Charset = detectCharset(head, r.Headers.Get("Content-Type"))
+	}
+
+	body, err := r.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	e, _ := charset.Lookup(r.Charset)
+	if e == nil || e == encoding.Nop || r.Charset == "utf-8" {
+		return body, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{transform.NewReader(body, e.NewDecoder()), body}, nil
+}
+
+// Text returns the body decoded into UTF-8 according to Charset. The charset is
+// detected if the downloader has not done it.
+func (r *DownloadResult) Text() (string, error) {
+	p := r.parsedContent()
+	if !p.textDecoded {
+		p.textDecoded = true
+
+		reader, err := r.textReader()
+		if err != nil {
+			p.textErr = err
+		} else {
+			var text []byte
+			text, p.textErr = ioutil.ReadAll(reader)
+			p.text = string(text)
+			reader.Close()
+		}
+	}
+	return p.text, p.textErr
+}
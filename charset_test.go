@@ -0,0 +1,75 @@
+package krawler
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func encodeText(t *testing.T, e encoding.Encoding, text string) []byte {
+	encoded, err := e.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatalf("encode %q failed, reason: %v", text, err)
+	}
+	return encoded
+}
+
+func TestDetectCharset(t *testing.T) {
+	tests := []struct {
+		name        string
+		head        []byte
+		contentType string
+		charset     string
+	}{
+		{"header", []byte("<html></html>"), "text/html; charset=gbk", "gbk"},
+		{"header alias", []byte("<html></html>"), "text/html; charset=GB2312", "gbk"},
+		{"meta charset", []byte(`<html><head><meta charset="shift_jis"></head></html>`), "text/html", "shift_jis"},
+		{"meta http-equiv", []byte(`<meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1">`), "text/html", "windows-1252"},
+		{"bom over header", []byte("\xef\xbb\xbf<html></html>"), "text/html; charset=gbk", "utf-8"},
+		{"valid utf-8", []byte("<p>你好</p>"), "text/html", "utf-8"},
+		{"cut utf-8", []byte("<p>你好</p>你")[:len("<p>你好</p>")+2], "text/html", "utf-8"},
+		{"invalid utf-8", []byte("<p>\xc4\xe3\xba\xc3</p>"), "text/html", "windows-1252"},
+	}
+
+	for _, test := range tests {
+		if charset := detectCharset(test.head, test.contentType); charset != test.charset {
+			t.Errorf("%s: expect %s, got %s", test.name, test.charset, charset)
+		}
+	}
+}
+
+func TestDownloadResultText(t *testing.T) {
+	text := "<html><body>你好, こんにちは</body></html>"
+
+	tests := []struct {
+		name        string
+		content     []byte
+		contentType string
+		text        string
+	}{
+		{"utf-8", []byte(text), "text/html; charset=utf-8", text},
+		{"gbk", encodeText(t, simplifiedchinese.GBK, "<p>你好</p>"), "text/html; charset=gbk", "<p>你好</p>"},
+		{"shift_jis", encodeText(t, japanese.ShiftJIS, "<p>こんにちは</p>"), "text/html; charset=shift_jis", "<p>こんにちは</p>"},
+		{"latin-1", encodeText(t, charmap.ISO8859_1, "<p>café</p>"), "text/html; charset=iso-8859-1", "<p>café</p>"},
+		{"meta charset", append([]byte(`<meta charset="gbk">`), encodeText(t, simplifiedchinese.GBK, "你好")...), "text/html", `<meta charset="gbk">你好`},
+	}
+
+	for _, test := range tests {
+		result := &DownloadResult{
+			Headers: http.Header{"Content-Type": {test.contentType}},
+			Content: test.content,
+		}
+		detectResultCharset(result)
+
+		decoded, err := result.Text()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if decoded != test.text {
+			t.Errorf("%s: expect %q, got %q", test.name, test.text, decoded)
+		}
+	}
+}
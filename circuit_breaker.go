@@ -0,0 +1,203 @@
+package krawler
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BreakerState is the state of the circuit of a host.
+type BreakerState string
+
+// States of a circuit
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// CircuitBreaker is a downloader middleware that stops sending tasks to a host
+// which keeps failing. When the error rate of the recent downloads of a host
+// reaches the threshold, the circuit opens and tasks of the host are postponed
+// instead of failed, so they do not use up their retries. After the circuit has
+// been open for a while, a few probe tasks are let through: the circuit closes if
+// they succeed and opens again if they fail.
+//
+// Connection errors, timeouts, 5xx responses and 429 or 503 responses postponed by
+// the auto-throttle count as failures.
+type CircuitBreaker struct {
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+	probes   map[*Task]*circuit
+
+	window       int
+	minRequests  int
+	errorRate    float64
+	openDuration time.Duration
+	maxProbes    int
+}
+
+type circuit struct {
+	host      string
+	state     BreakerState
+	outcomes  []bool
+	next      int
+	openUntil time.Time
+	probing   int
+}
+
+// NewCircuitBreaker creates a circuit breaker from config.
+func NewCircuitBreaker(config BreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		circuits:     make(map[string]*circuit),
+		probes:       make(map[*Task]*circuit),
+		window:       config.Window,
+		minRequests:  config.MinRequests,
+		errorRate:    config.ErrorRate,
+		openDuration: config.OpenDuration,
+		maxProbes:    config.HalfOpenProbes,
+	}
+}
+
+func (b *CircuitBreaker) circuit(task *Task) *circuit {
+	u, err := url.Parse(task.URL)
+	if err != nil {
+		return nil
+	}
+
+	host := u.Hostname()
+	c := b.circuits[host]
+	if c == nil {
+		c = &circuit{host: host, state: BreakerClosed}
+		b.circuits[host] = c
+	}
+	return c
+}
+
+// BeforeDownload postpones tasks of hosts whose circuit is open, and lets probe
+// tasks through when the circuit is half-open.
+func (b *CircuitBreaker) BeforeDownload(task *Task) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuit(task)
+	if c == nil || c.state == BreakerClosed {
+		return nil
+	}
+
+	if c.state == BreakerOpen {
+		if wait := time.Until(c.openUntil); wait > 0 {
+			return &PostponeError{Reason: fmt.Sprintf("circuit of host %s is open", c.host), Delay: wait}
+		}
+		c.state = BreakerHalfOpen
+		log.Infof("Circuit of host %s is half-open, probing whether it recovers", c.host)
+	}
+
+	if c.probing >= b.maxProbes {
+		return &PostponeError{Reason: fmt.Sprintf("circuit of host %s is half-open", c.host), Delay: b.openDuration}
+	}
+	c.probing++
+	b.probes[task] = c
+	return nil
+}
+
+// AfterDownload records the outcome of a download.
+func (b *CircuitBreaker) AfterDownload(result *DownloadResult) error {
+	b.record(result.Task, result.StatusCode < 500)
+	return nil
+}
+
+// OnDownloadError records the outcome of a failed download. Errors that do not
+// tell anything about the health of the host are ignored.
+func (b *CircuitBreaker) OnDownloadError(result *DownloadResult) {
+	if postpone, postponed := result.Err.(*PostponeError); (postponed && !postpone.Failure) || result.Err == ErrProxyBanned || isPermanentDownloadError(result.Err) {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		if c := b.probes[result.Task]; c != nil {
+			delete(b.probes, result.Task)
+			c.probing--
+		}
+		return
+	}
+
+	b.record(result.Task, false)
+}
+
+func (b *CircuitBreaker) record(task *Task, success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if c := b.probes[task]; c != nil {
+		delete(b.probes, task)
+		c.probing--
+		if success {
+			c.close()
+		} else {
+			b.open(c, "the probe fails")
+		}
+		return
+	}
+
+	c := b.circuit(task)
+	if c == nil || c.state != BreakerClosed {
+		return
+	}
+
+	if len(c.outcomes) < b.window {
+		c.outcomes = append(c.outcomes, success)
+	} else {
+		c.outcomes[c.next] = success
+		c.next = (c.next + 1) % b.window
+	}
+	if len(c.outcomes) < b.minRequests {
+		return
+	}
+
+	failures := 0
+	for _, outcome := range c.outcomes {
+		if !outcome {
+			failures++
+		}
+	}
+	if rate := float64(failures) / float64(len(c.outcomes)); rate >= b.errorRate {
+		b.open(c, fmt.Sprintf("error rate reaches %.0f%%", rate*100))
+	}
+}
+
+func (b *CircuitBreaker) open(c *circuit, reason string) {
+	c.state = BreakerOpen
+	c.openUntil = time.Now().Add(b.openDuration)
+	log.Warnf("Circuit of host %s opens for %v because %s", c.host, b.openDuration, reason)
+}
+
+func (c *circuit) close() {
+	c.state = BreakerClosed
+	c.outcomes = nil
+	c.next = 0
+	log.Infof("Circuit of host %s closes because the probe succeeds", c.host)
+}
+
+// States returns the state of every circuit that is not closed.
+func (b *CircuitBreaker) States() map[string]BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	states := make(map[string]BreakerState)
+	for host, c := range b.circuits {
+		if c.state != BreakerClosed {
+			states[host] = c.state
+		}
+	}
+	return states
+}
+
+// ReportStats implements StatsReporter#ReportStats
+func (b *CircuitBreaker) ReportStats(stats *Stats) {
+	for host, state := range b.States() {
+		stats.Breakers[host] = state
+	}
+}
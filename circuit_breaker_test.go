@@ -0,0 +1,141 @@
+package krawler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestBreaker() *CircuitBreaker {
+	return NewCircuitBreaker(BreakerConfig{
+		Enabled:        true,
+		Window:         4,
+		MinRequests:    3,
+		ErrorRate:      0.5,
+		OpenDuration:   50 * time.Millisecond,
+		HalfOpenProbes: 1,
+	})
+}
+
+// breakerDownload passes a task of example.com through the breaker and returns the
+// error of BeforeDownload.
+func breakerDownload(b *CircuitBreaker, statusCode int, err error) error {
+	task := &Task{URL: "http://example.com/", Method: http.MethodGet}
+	if beforeErr := b.BeforeDownload(task); beforeErr != nil {
+		return beforeErr
+	}
+
+	result := &DownloadResult{Task: task, StatusCode: statusCode, Err: err}
+	if err != nil {
+		b.OnDownloadError(result)
+	} else {
+		b.AfterDownload(result)
+	}
+	return nil
+}
+
+func breakerState(b *CircuitBreaker) BreakerState {
+	if state, exists := b.States()["example.com"]; exists {
+		return state
+	}
+	return BreakerClosed
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		err        error
+		state      BreakerState
+	}{
+		{"success", 200, nil, BreakerClosed},
+		{"client error", 404, nil, BreakerClosed},
+		{"server error", 500, nil, BreakerOpen},
+		{"timeout", 0, ErrDownloadTimeout, BreakerOpen},
+		{"throttled 503", 503, &PostponeError{Reason: "503", Delay: time.Second, Failure: true}, BreakerOpen},
+		{"postponed", 0, &PostponeError{Reason: "login", Delay: time.Second}, BreakerClosed},
+		{"proxy banned", 0, ErrProxyBanned, BreakerClosed},
+		{"body too large", 200, ErrBodyTooLarge, BreakerClosed},
+	}
+
+	for _, test := range tests {
+		b := newTestBreaker()
+		for i := 0; i < 3; i++ {
+			breakerDownload(b, test.statusCode, test.err)
+		}
+		if state := breakerState(b); state != test.state {
+			t.Errorf("%s: expect %s, got %s", test.name, test.state, state)
+		}
+	}
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	b := newTestBreaker()
+	for _, statusCode := range []int{500, 200, 200, 200, 200, 500} {
+		breakerDownload(b, statusCode, nil)
+	}
+	// the window keeps the last 4 outcomes, the first failure has left it
+	if state := breakerState(b); state != BreakerClosed {
+		t.Fatalf("expect closed below the error rate, got %s", state)
+	}
+
+	breakerDownload(b, 503, nil)
+	if state := breakerState(b); state != BreakerOpen {
+		t.Fatalf("expect open at the error rate, got %s", state)
+	}
+}
+
+func TestCircuitBreakerProbes(t *testing.T) {
+	b := newTestBreaker()
+	for i := 0; i < 3; i++ {
+		breakerDownload(b, 500, nil)
+	}
+
+	err := breakerDownload(b, 200, nil)
+	if postpone, ok := err.(*PostponeError); !ok || postpone.Failure {
+		t.Fatalf("open circuit: expect the task to be postponed, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	probe := &Task{URL: "http://example.com/probe", Method: http.MethodGet}
+	if err := b.BeforeDownload(probe); err != nil {
+		t.Fatalf("half-open circuit: expect a probe, got %v", err)
+	}
+	if state := breakerState(b); state != BreakerHalfOpen {
+		t.Fatalf("expect half-open, got %s", state)
+	}
+	if err := breakerDownload(b, 200, nil); err == nil {
+		t.Fatalf("half-open circuit: expect tasks beyond the probes to be postponed")
+	}
+
+	b.AfterDownload(&DownloadResult{Task: probe, StatusCode: 502})
+	if state := breakerState(b); state != BreakerOpen {
+		t.Fatalf("failed probe: expect open, got %s", state)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := breakerDownload(b, 200, nil); err != nil {
+		t.Fatalf("half-open circuit: expect a probe, got %v", err)
+	}
+	if state := breakerState(b); state != BreakerClosed {
+		t.Fatalf("successful probe: expect closed, got %s", state)
+	}
+}
+
+func TestCircuitBreakerReleasesPostponedProbe(t *testing.T) {
+	b := newTestBreaker()
+	for i := 0; i < 3; i++ {
+		breakerDownload(b, 500, nil)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	if err := breakerDownload(b, 0, &PostponeError{Reason: "login", Delay: time.Second}); err != nil {
+		t.Fatalf("expect a probe, got %v", err)
+	}
+	if err := breakerDownload(b, 200, nil); err != nil {
+		t.Fatalf("expect another probe once the postponed one is released, got %v", err)
+	}
+	if state := breakerState(b); state != BreakerClosed {
+		t.Fatalf("expect closed, got %s", state)
+	}
+}
@@ -2,6 +2,7 @@ package krawler
 
 import (
 	"io/ioutil"
+	"net/http"
 	"time"
 
 	"github.com/rifflock/lfshook"
@@ -12,8 +13,14 @@ import (
 
 // Config defines the structure of a YAML config file.
 type Config struct {
-	Logger  LoggerConfig
-	Request RequestConfig
+	Logger    LoggerConfig
+	Request   RequestConfig
+	Transport TransportConfig
+	ProxyPool ProxyPoolConfig
+	Headers   HeadersConfig
+	Response  ResponseConfig
+	Throttle  ThrottleConfig
+	Breaker   BreakerConfig
 }
 
 // LoggerConfig defines the structure of LoggerConfig
@@ -25,11 +32,172 @@ type LoggerConfig struct {
 
 // RequestConfig defines the structure of RequestConfig
 type RequestConfig struct {
-	UserAgent      string
-	Timeout        time.Duration
+	UserAgent string
+
+	// Timeout limits the whole download of a task, including reading the body.
+	Timeout time.Duration
+
+	// ConnectTimeout, TLSHandshakeTimeout and ResponseHeaderTimeout limit the phases
+	// of a download. Zero means no limit other than Timeout.
+	ConnectTimeout        time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+
 	MaxRetryTimes  int
 	FollowRedirect bool
+	MaxRedirects   int
 	Concurrency    int
+
+	// DeduplicateFinalURL marks the final URL of a redirected task as visited, and
+	// drops the result if the final URL has been visited before. Retries of a task
+	// are not checked. It works with the queues of this package.
+	DeduplicateFinalURL bool
+}
+
+// TransportConfig defines the structure of TransportConfig
+type TransportConfig struct {
+	// Proxy is the URL of a http, https or socks5 proxy. If empty, the proxy is
+	// read from the environment variables HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	Proxy string
+
+	// CACertFiles are PEM files of CA certificates trusted besides the system ones.
+	CACertFiles []string
+
+	// ClientCertFile and ClientKeyFile are PEM files of the client certificate.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	InsecureSkipVerify bool
+	DisableHTTP2       bool
+
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	DisableKeepAlives   bool
+}
+
+// ProxyPoolConfig defines the structure of ProxyPoolConfig. The pool is enabled if
+// any proxy is configured.
+type ProxyPoolConfig struct {
+	// Proxies is a list of proxy URLs.
+	Proxies []string
+
+	// File is a file of proxy URLs, one per line.
+	File string
+
+	// Strategy is one of round-robin, random and sticky. A sticky pool keeps using
+	// the same proxy for a host until the proxy becomes unhealthy.
+	Strategy string
+
+	// MaxFailures is the number of consecutive connection errors that marks a proxy
+	// as unhealthy.
+	MaxFailures int
+
+	// Cooldown is how long an unhealthy proxy stays out of the pool before it is
+	// tested again.
+	Cooldown time.Duration
+
+	// BanStatusCodes and BanBodyPatterns are signals of a ban. A proxy is marked as
+	// unhealthy immediately if a response matches any of them.
+	BanStatusCodes  []int
+	BanBodyPatterns []string
+
+	// TestURL is requested through a proxy that has cooled down. The proxy comes
+	// back to the pool if the request succeeds. If empty, the proxy comes back
+	// without testing.
+	TestURL string
+}
+
+// HeadersConfig defines the structure of HeadersConfig. Headers of a request are
+// merged from the profile, the User-Agent pool, Default, Hosts and Task.Headers in
+// this order, and a later source replaces every value of the fields it sets.
+type HeadersConfig struct {
+	// Profile is the name of a header profile in HeaderProfiles, such as chrome,
+	// firefox or safari.
+	Profile string
+
+	// UserAgents is a pool of User-Agents. If empty, the User-Agents of the profile
+	// are used, or RequestConfig.UserAgent if there is no profile either.
+	UserAgents []string
+
+	// Rotation is one of round-robin, random and sticky. A sticky rotation keeps
+	// using the same User-Agent for a host.
+	Rotation string
+
+	// Default are headers sent with every request.
+	Default http.Header
+
+	// Hosts are headers sent with requests to a host or its sub-domains.
+	Hosts map[string]http.Header
+}
+
+// ResponseConfig defines the structure of ResponseConfig
+type ResponseConfig struct {
+	// MaxBodySize limits the size of a body in bytes. Zero means no limit.
+	MaxBodySize int64
+
+	// TruncateBody keeps the first MaxBodySize bytes of a larger body instead of
+	// failing the download.
+	TruncateBody bool
+
+	// AllowedContentTypes is a list of media types, such as text/html or text/*,
+	// checked before the body is read. An empty list allows every type.
+	AllowedContentTypes []string
+
+	// StreamThreshold is the size in bytes above which a body is written into a
+	// temporary file instead of memory. Zero disables streaming.
+	StreamThreshold int64
+
+	// StreamDir is the directory of temporary files. Default to the system one.
+	StreamDir string
+
+	// DisableDecompression keeps gzip, deflate and br bodies encoded. The
+	// Accept-Encoding header is not sent unless a task sets it.
+	DisableDecompression bool
+}
+
+// ThrottleConfig defines the structure of ThrottleConfig. Auto-throttling adapts
+// the delay between requests to a host to the latency of the host, slows down when
+// the host responds 429 or 503 and honours Retry-After. A task answered with 429 or
+// 503 is postponed, which counts as a retry. As the pace of every host is
+// controlled by its delay, Concurrency only needs to be large enough for the hosts
+// crawled at the same time.
+type ThrottleConfig struct {
+	Enabled bool
+
+	// StartDelay is the delay of a host before any of its responses is seen.
+	StartDelay time.Duration
+
+	// MinDelay and MaxDelay bound the delay of a host. A task that would wait for
+	// longer than MaxDelay is put back into the queue instead.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// TargetConcurrency is the average number of requests that should be running
+	// against a host at the same time.
+	TargetConcurrency float64
+}
+
+// BreakerConfig defines the structure of BreakerConfig. If enabled, a
+// CircuitBreaker is installed onto the engine.
+type BreakerConfig struct {
+	Enabled bool
+
+	// Window is the number of recent downloads of a host the error rate is
+	// computed from. The rate is not checked before MinRequests downloads.
+	Window      int
+	MinRequests int
+
+	// ErrorRate is the rate of failures, between 0 and 1, that opens the circuit.
+	ErrorRate float64
+
+	// OpenDuration is how long a circuit stays open before it is probed.
+	OpenDuration time.Duration
+
+	// HalfOpenProbes is the number of tasks let through at the same time to probe
+	// a half-open circuit.
+	HalfOpenProbes int
 }
 
 func GetDefaultConfig() *Config {
@@ -42,10 +210,34 @@ func GetDefaultConfig() *Config {
 		Request: RequestConfig{
 			Concurrency:    5,
 			FollowRedirect: true,
+			MaxRedirects:   10,
 			MaxRetryTimes:  3,
 			Timeout:        time.Second * 5,
 			UserAgent:      "krawler/" + constant.KrawlerVersion,
 		},
+		Transport: TransportConfig{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		ProxyPool: ProxyPoolConfig{
+			Strategy:       ProxyStrategyRoundRobin,
+			MaxFailures:    3,
+			Cooldown:       5 * time.Minute,
+			BanStatusCodes: []int{http.StatusForbidden, http.StatusTooManyRequests},
+		},
+		Throttle: ThrottleConfig{
+			StartDelay:        time.Second,
+			MaxDelay:          time.Minute,
+			TargetConcurrency: 1,
+		},
+		Breaker: BreakerConfig{
+			Window:         20,
+			MinRequests:    10,
+			ErrorRate:      0.5,
+			OpenDuration:   30 * time.Second,
+			HalfOpenProbes: 1,
+		},
 	}
 
 }
@@ -77,8 +269,52 @@ func (config *Config) checkConfig() {
 		config.Request.Timeout = defaultConfig.Request.Timeout
 	}
 
+	if config.Request.MaxRedirects <= 0 {
+		log.Warnf("%v is invalid for maximum redirects configuration, set to default value %v", config.Request.MaxRedirects, defaultConfig.Request.MaxRedirects)
+		config.Request.MaxRedirects = defaultConfig.Request.MaxRedirects
+	}
+
 	if config.Request.Concurrency <= 0 {
 		log.Warnf("%v is invalid for request timeout configuration, set to default value %v", config.Request.Concurrency, defaultConfig.Request.Concurrency)
 		config.Request.Concurrency = defaultConfig.Request.Concurrency
 	}
+
+	if config.Throttle.Enabled {
+		if config.Throttle.TargetConcurrency <= 0 {
+			log.Warnf("%v is invalid for throttle target concurrency configuration, set to default value %v", config.Throttle.TargetConcurrency, defaultConfig.Throttle.TargetConcurrency)
+			config.Throttle.TargetConcurrency = defaultConfig.Throttle.TargetConcurrency
+		}
+
+		if config.Throttle.MaxDelay <= 0 || config.Throttle.MaxDelay < config.Throttle.MinDelay {
+			log.Warnf("%v is invalid for throttle maximum delay configuration, set to default value %v", config.Throttle.MaxDelay, defaultConfig.Throttle.MaxDelay)
+			config.Throttle.MaxDelay = defaultConfig.Throttle.MaxDelay
+		}
+	}
+
+	if config.Breaker.Enabled {
+		if config.Breaker.Window <= 0 {
+			log.Warnf("%v is invalid for breaker window configuration, set to default value %v", config.Breaker.Window, defaultConfig.Breaker.Window)
+			config.Breaker.Window = defaultConfig.Breaker.Window
+		}
+
+		if config.Breaker.MinRequests <= 0 || config.Breaker.MinRequests > config.Breaker.Window {
+			log.Warnf("%v is invalid for breaker minimum requests configuration, set to window size %v", config.Breaker.MinRequests, config.Breaker.Window)
+			config.Breaker.MinRequests = config.Breaker.Window
+		}
+
+		if config.Breaker.ErrorRate <= 0 || config.Breaker.ErrorRate > 1 {
+			log.Warnf("%v is invalid for breaker error rate configuration, set to default value %v", config.Breaker.ErrorRate, defaultConfig.Breaker.ErrorRate)
+			config.Breaker.ErrorRate = defaultConfig.Breaker.ErrorRate
+		}
+
+		if config.Breaker.OpenDuration <= 0 {
+			log.Warnf("%v is invalid for breaker open duration configuration, set to default value %v", config.Breaker.OpenDuration, defaultConfig.Breaker.OpenDuration)
+			config.Breaker.OpenDuration = defaultConfig.Breaker.OpenDuration
+		}
+
+		if config.Breaker.HalfOpenProbes <= 0 {
+			log.Warnf("%v is invalid for breaker half-open probes configuration, set to default value %v", config.Breaker.HalfOpenProbes, defaultConfig.Breaker.HalfOpenProbes)
+			config.Breaker.HalfOpenProbes = defaultConfig.Breaker.HalfOpenProbes
+		}
+	}
 }
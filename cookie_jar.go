@@ -0,0 +1,362 @@
+package krawler
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	json "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieStore persists the cookies of sessions. Cookies are identified by their
+// domain, path and name. The domain of a cookie that is sent to sub-domains starts
+// with a dot.
+type CookieStore interface {
+	// Load returns every cookie of a session.
+	Load(session string) ([]*http.Cookie, error)
+
+	// Save stores cookies of a session and replaces existing cookies with the same
+	// identity. A cookie with a negative MaxAge is removed.
+	Save(session string, cookies []*http.Cookie) error
+}
+
+// CookieJar stores cookies of named sessions in memory and in a CookieStore.
+// Cookies of a session are only sent with tasks of the same session.
+type CookieJar struct {
+	mutex           sync.Mutex
+	store           CookieStore
+	sessions        map[string]*cookieSession
+	refreshInterval time.Duration
+}
+
+type cookieSession struct {
+	cookies  map[string]*http.Cookie
+	loadedAt time.Time
+}
+
+// NewCookieJar creates a cookie jar backed by store. If refreshInterval is positive,
+// a session is reloaded from the store once it is older than refreshInterval, so
+// that cookies set by other workers sharing the store are picked up.
+func NewCookieJar(store CookieStore, refreshInterval time.Duration) *CookieJar {
+	return &CookieJar{
+		store:           store,
+		sessions:        make(map[string]*cookieSession),
+		refreshInterval: refreshInterval,
+	}
+}
+
+func cookieID(cookie *http.Cookie) string {
+	return cookie.Domain + ";" + cookie.Path + ";" + cookie.Name
+}
+
+// session returns a session, loading it from the store if necessary.
+func (j *CookieJar) session(name string) *cookieSession {
+	session := j.sessions[name]
+	if session != nil && (j.refreshInterval <= 0 || time.Since(session.loadedAt) < j.refreshInterval) {
+		return session
+	}
+
+	cookies, err := j.store.Load(name)
+	if err != nil {
+		log.Errorf("Fail to load cookies of session %s, reason: %v", name, err)
+		if session != nil {
+			return session
+		}
+	}
+
+	session = &cookieSession{cookies: make(map[string]*http.Cookie), loadedAt: time.Now()}
+	for _, cookie := range cookies {
+		session.cookies[cookieID(cookie)] = cookie
+	}
+	j.sessions[name] = session
+	return session
+}
+
+// SetCookies stores cookies received from u in a session.
+func (j *CookieJar) SetCookies(sessionName string, u *url.URL, cookies []*http.Cookie) {
+	if len(cookies) == 0 {
+		return
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	session := j.session(sessionName)
+	host := strings.ToLower(u.Hostname())
+	now := time.Now()
+
+	var changed []*http.Cookie
+	for _, received := range cookies {
+		cookie := *received
+
+		if cookie.Domain == "" {
+			cookie.Domain = host
+		} else {
+			domain := strings.ToLower(strings.TrimPrefix(cookie.Domain, "."))
+			if host != domain && !strings.HasSuffix(host, "."+domain) {
+				continue
+			}
+			if suffix, _ := publicsuffix.PublicSuffix(domain); suffix == domain && host != domain {
+				continue
+			}
+			cookie.Domain = "." + domain
+		}
+
+		if cookie.Path == "" || !strings.HasPrefix(cookie.Path, "/") {
+			cookie.Path = defaultCookiePath(u.Path)
+		}
+
+		if cookie.MaxAge > 0 {
+			cookie.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+			cookie.MaxAge = 0
+		} else if cookie.MaxAge == 0 && !cookie.Expires.IsZero() && !cookie.Expires.After(now) {
+			cookie.MaxAge = -1
+		}
+
+		id := cookieID(&cookie)
+		if cookie.MaxAge < 0 {
+			delete(session.cookies, id)
+		} else {
+			session.cookies[id] = &cookie
+		}
+		changed = append(changed, &cookie)
+	}
+
+	if len(changed) > 0 {
+		if err := j.store.Save(sessionName, changed); err != nil {
+			log.Errorf("Fail to save cookies of session %s, reason: %v", sessionName, err)
+		}
+	}
+}
+
+// Cookies returns cookies of a session that should be sent to u.
+func (j *CookieJar) Cookies(sessionName string, u *url.URL) []*http.Cookie {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	session := j.session(sessionName)
+	host := strings.ToLower(u.Hostname())
+	requestPath := u.Path
+	if requestPath == "" {
+		requestPath = "/"
+	}
+	now := time.Now()
+
+	var matched []*http.Cookie
+	for id, cookie := range session.cookies {
+		if !cookie.Expires.IsZero() && !cookie.Expires.After(now) {
+			delete(session.cookies, id)
+			continue
+		}
+		if cookie.Secure && u.Scheme != "https" {
+			continue
+		}
+		if strings.HasPrefix(cookie.Domain, ".") {
+			domain := cookie.Domain[1:]
+			if host != domain && !strings.HasSuffix(host, cookie.Domain) {
+				continue
+			}
+		} else if host != cookie.Domain {
+			continue
+		}
+		if !matchCookiePath(requestPath, cookie.Path) {
+			continue
+		}
+		matched = append(matched, cookie)
+	}
+
+	// cookies with longer paths are listed first
+	sort.Slice(matched, func(a, b int) bool {
+		return len(matched[a].Path) > len(matched[b].Path)
+	})
+
+	cookies := make([]*http.Cookie, 0, len(matched))
+	for _, cookie := range matched {
+		cookies = append(cookies, &http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return cookies
+}
+
+// defaultCookiePath implements the default-path algorithm of RFC 6265.
+func defaultCookiePath(requestPath string) string {
+	if requestPath == "" || requestPath[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(requestPath, "/")
+	if i == 0 {
+		return "/"
+	}
+	return requestPath[:i]
+}
+
+// matchCookiePath implements the path-match algorithm of RFC 6265.
+func matchCookiePath(requestPath, cookiePath string) bool {
+	if requestPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
+
+// FileCookieStore stores cookies of every session in a JSON file under a directory.
+type FileCookieStore struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+// NewFileCookieStore creates a cookie store that saves files into dir.
+func NewFileCookieStore(dir string) (*FileCookieStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create cookie directory %s failed, reason: %v", dir, err)
+	}
+	return &FileCookieStore{dir: dir}, nil
+}
+
+func (s *FileCookieStore) path(session string) string {
+	return filepath.Join(s.dir, url.PathEscape(session)+".json")
+}
+
+func (s *FileCookieStore) load(session string) ([]*http.Cookie, error) {
+	raw, err := ioutil.ReadFile(s.path(session))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cookies []*http.Cookie
+	if err := json.Unmarshal(raw, &cookies); err != nil {
+		return nil, fmt.Errorf("invalid cookie file %s, reason: %v", s.path(session), err)
+	}
+	return cookies, nil
+}
+
+// Load reads cookies of a session from its file.
+func (s *FileCookieStore) Load(session string) ([]*http.Cookie, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.load(session)
+}
+
+// Save merges cookies into the file of a session.
+func (s *FileCookieStore) Save(session string, cookies []*http.Cookie) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, err := s.load(session)
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]*http.Cookie)
+	for _, cookie := range stored {
+		merged[cookieID(cookie)] = cookie
+	}
+	for _, cookie := range cookies {
+		if cookie.MaxAge < 0 {
+			delete(merged, cookieID(cookie))
+		} else {
+			merged[cookieID(cookie)] = cookie
+		}
+	}
+
+	list := make([]*http.Cookie, 0, len(merged))
+	for _, cookie := range merged {
+		list = append(list, cookie)
+	}
+	raw, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that a crash never leaves a broken file
+	tmp := s.path(session) + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(session))
+}
+
+// RedisCookieStore stores cookies of every session in a redis hash, so that
+// workers sharing a RedisQueue can also share sessions.
+type RedisCookieStore struct {
+	redis     *redis.Client
+	keyPrefix string
+}
+
+// NewRedisCookieStore creates a cookie store in redis. id is usually the id of the
+// RedisQueue used by the crawl.
+func NewRedisCookieStore(id string, redisOptions *redis.Options) *RedisCookieStore {
+	return &RedisCookieStore{
+		redis:     redis.NewClient(redisOptions),
+		keyPrefix: fmt.Sprintf("{krawler:%s}:cookies:", id),
+	}
+}
+
+// Load reads cookies of a session from redis.
+func (s *RedisCookieStore) Load(session string) ([]*http.Cookie, error) {
+	values, err := s.redis.HGetAll(s.keyPrefix + session).Result()
+	if err != nil {
+		return nil, fmt.Errorf("fail to load cookies, reason: %v", err)
+	}
+
+	cookies := make([]*http.Cookie, 0, len(values))
+	for _, value := range values {
+		cookie := new(http.Cookie)
+		if err := json.Unmarshal([]byte(value), cookie); err != nil {
+			return nil, fmt.Errorf("fail to unmarshal a cookie, reason: %v", err)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies, nil
+}
+
+// Save writes cookies of a session into redis.
+func (s *RedisCookieStore) Save(session string, cookies []*http.Cookie) error {
+	key := s.keyPrefix + session
+	pipe := s.redis.TxPipeline()
+	for _, cookie := range cookies {
+		if cookie.MaxAge < 0 {
+			pipe.HDel(key, cookieID(cookie))
+			continue
+		}
+
+		value, err := json.Marshal(cookie)
+		if err != nil {
+			return fmt.Errorf("fail to marshal a cookie, reason: %v", err)
+		}
+		pipe.HSet(key, cookieID(cookie), value)
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		return fmt.Errorf("fail to save cookies, reason: %v", err)
+	}
+	return nil
+}
+
+// Close closes the redis connection.
+func (s *RedisCookieStore) Close() error {
+	return s.redis.Close()
+}
+
+// Close closes the store of the jar if it can be closed.
+func (j *CookieJar) Close() error {
+	if closer, ok := j.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package krawler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDefaultCookiePath(t *testing.T) {
+	tests := []struct {
+		requestPath string
+		expected    string
+	}{
+		{"", "/"},
+		{"relative", "/"},
+		{"/", "/"},
+		{"/a", "/"},
+		{"/a/", "/a"},
+		{"/a/b", "/a"},
+		{"/a/b/c.html", "/a/b"},
+	}
+
+	for _, test := range tests {
+		if path := defaultCookiePath(test.requestPath); path != test.expected {
+			t.Errorf("%q: expect %q, got %q", test.requestPath, test.expected, path)
+		}
+	}
+}
+
+func TestMatchCookiePath(t *testing.T) {
+	tests := []struct {
+		requestPath string
+		cookiePath  string
+		matched     bool
+	}{
+		{"/", "/", true},
+		{"/a", "/", true},
+		{"/a", "/a", true},
+		{"/a/b", "/a", true},
+		{"/a/b", "/a/", true},
+		{"/a/", "/a", true},
+		{"/ab", "/a", false},
+		{"/a", "/a/", false},
+		{"/", "/a", false},
+		{"/b/a", "/a", false},
+		{"/A", "/a", false},
+	}
+
+	for _, test := range tests {
+		if matched := matchCookiePath(test.requestPath, test.cookiePath); matched != test.matched {
+			t.Errorf("%q against %q: expect matched %v, got %v", test.requestPath, test.cookiePath, test.matched, matched)
+		}
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krawler-cookies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileCookieStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar := NewCookieJar(store, 0)
+
+	origin, _ := url.Parse("https://www.example.com/account/login")
+	jar.SetCookies("alice", origin, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "1", Domain: ".example.com"},
+		{Name: "root", Value: "1", Path: "/"},
+		{Name: "secure", Value: "1", Path: "/", Secure: true},
+		{Name: "other", Value: "1", Domain: "other.com"},
+		{Name: "suffix", Value: "1", Domain: "com"},
+		{Name: "expired", Value: "1", Path: "/", MaxAge: -1},
+	})
+
+	tests := []struct {
+		session string
+		url     string
+		cookies string
+	}{
+		{"alice", "https://www.example.com/account/profile", "domain host root secure"},
+		{"alice", "https://www.example.com/", "root secure"},
+		{"alice", "http://www.example.com/", "root"},
+		{"alice", "https://api.example.com/account/", "domain"},
+		{"alice", "https://example.com/account", "domain"},
+		{"alice", "https://www.example.com/accounts", "root secure"},
+		{"alice", "https://notexample.com/account", ""},
+		{"alice", "https://other.com/", ""},
+		{"bob", "https://www.example.com/account/profile", ""},
+	}
+
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		var names []string
+		for _, cookie := range jar.Cookies(test.session, u) {
+			names = append(names, cookie.Name)
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, " "); got != test.cookies {
+			t.Errorf("%s of %s: expect cookies %q, got %q", test.url, test.session, test.cookies, got)
+		}
+	}
+
+	// cookies are loaded from the store by another jar
+	u, _ := url.Parse("https://www.example.com/account/profile")
+	if cookies := NewCookieJar(store, 0).Cookies("alice", u); len(cookies) != 4 {
+		t.Errorf("expect 4 cookies loaded from the store, got %d", len(cookies))
+	}
+}
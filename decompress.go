@@ -0,0 +1,80 @@
+package krawler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// acceptEncoding lists the content codings HTTPDownloader can decode.
+const acceptEncoding = "gzip, deflate, br"
+
+// decompress wraps body with decoders of the content codings listed in the
+// Content-Encoding header, and removes the header fields that only describe the
+// encoded body. An empty body, such as the one of a HEAD request or a 204 or 304
+// response, is left as it is.
+func decompress(body io.Reader, header http.Header) (io.Reader, error) {
+	if header.Get("Content-Encoding") == "" {
+		return body, nil
+	}
+
+	buffered := bufio.NewReader(body)
+	if _, err := buffered.Peek(1); err == io.EOF {
+		header.Del("Content-Encoding")
+		header.Del("Content-Length")
+		return buffered, nil
+	}
+	body = buffered
+
+	codings := strings.Split(header.Get("Content-Encoding"), ",")
+
+	// codings are listed in the order they are applied, so decode from the last one
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+
+		var err error
+		switch coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			body, err = gzip.NewReader(body)
+		case "deflate":
+			body, err = newDeflateReader(body)
+		case "br":
+			body = brotli.NewReader(body)
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %s", coding)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode %s body failed, reason: %v", coding, err)
+		}
+	}
+
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	return body, nil
+}
+
+// newDeflateReader decodes a deflate body. Servers send either zlib-wrapped data as
+// the specification says, or raw deflate data.
+func newDeflateReader(body io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(body)
+	header, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	// a zlib header has CM=8 in the lower bits of the first byte and makes the
+	// first two bytes a multiple of 31
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
@@ -0,0 +1,199 @@
+package krawler
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func encode(t *testing.T, coding string, content []byte) []byte {
+	buffer := new(bytes.Buffer)
+	var writer io.WriteCloser
+	switch coding {
+	case "gzip":
+		writer = gzip.NewWriter(buffer)
+	case "zlib":
+		writer = zlib.NewWriter(buffer)
+	case "flate":
+		writer, _ = flate.NewWriter(buffer, flate.DefaultCompression)
+	case "br":
+		writer = brotli.NewWriter(buffer)
+	default:
+		t.Fatalf("unknown coding %s", coding)
+	}
+	writer.Write(content)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	content := []byte("<html><body>hello, krawler</body></html>")
+
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		fail            bool
+	}{
+		{"none", "", content, false},
+		{"identity", "identity", content, false},
+		{"gzip", "gzip", encode(t, "gzip", content), false},
+		{"x-gzip", "x-gzip", encode(t, "gzip", content), false},
+		{"zlib deflate", "deflate", encode(t, "zlib", content), false},
+		{"raw deflate", "deflate", encode(t, "flate", content), false},
+		{"br", "br", encode(t, "br", content), false},
+		{"case insensitive", "GZIP", encode(t, "gzip", content), false},
+		{"gzip then br", "gzip, br", encode(t, "br", encode(t, "gzip", content)), false},
+		{"unsupported", "compress", content, true},
+		{"corrupted gzip", "gzip", content, true},
+	}
+
+	for _, test := range tests {
+		header := http.Header{"Content-Length": {"1"}}
+		if test.contentEncoding != "" {
+			header.Set("Content-Encoding", test.contentEncoding)
+		}
+
+		reader, err := decompress(bytes.NewReader(test.body), header)
+		if err == nil {
+			var decoded []byte
+			decoded, err = ioutil.ReadAll(reader)
+			if err == nil && !bytes.Equal(decoded, content) {
+				t.Errorf("%s: got %q", test.name, decoded)
+			}
+		}
+		if (err != nil) != test.fail {
+			t.Errorf("%s: expect failure %v, got error %v", test.name, test.fail, err)
+		}
+
+		if !test.fail && test.contentEncoding != "" && (header.Get("Content-Encoding") != "" || header.Get("Content-Length") != "") {
+			t.Errorf("%s: encoding headers are not removed: %v", test.name, header)
+		}
+	}
+}
+
+func TestDecompressEmptyBody(t *testing.T) {
+	for _, coding := range []string{"gzip", "deflate", "br", "gzip, br"} {
+		header := http.Header{"Content-Encoding": {coding}, "Content-Length": {"0"}}
+		reader, err := decompress(bytes.NewReader(nil), header)
+		if err != nil {
+			t.Errorf("%s: expect no error, got %v", coding, err)
+			continue
+		}
+		if decoded, err := ioutil.ReadAll(reader); err != nil || len(decoded) != 0 {
+			t.Errorf("%s: expect an empty body, got %q and %v", coding, decoded, err)
+		}
+		if len(header) != 0 {
+			t.Errorf("%s: encoding headers are not removed: %v", coding, header)
+		}
+	}
+}
+
+func TestHTTPDownloaderDecompressionWithoutBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		switch r.URL.Path {
+		case "/no-content":
+			w.WriteHeader(http.StatusNoContent)
+		case "/not-modified":
+			w.WriteHeader(http.StatusNotModified)
+		case "/empty":
+			w.Header().Set("Content-Length", "0")
+		default:
+			w.Write(encode(t, "gzip", []byte("hello, krawler")))
+		}
+	}))
+	defer server.Close()
+
+	downloader := NewHTTPDownloader(GetDefaultConfig())
+	defer downloader.Shutdown()
+
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+	}{
+		{http.MethodHead, "/", http.StatusOK},
+		{http.MethodGet, "/no-content", http.StatusNoContent},
+		{http.MethodGet, "/not-modified", http.StatusNotModified},
+		{http.MethodGet, "/empty", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: server.URL + test.path, Method: test.method}, ch)
+		result := <-ch
+		if result.Err != nil {
+			t.Errorf("%s %s: expect no error, got %v", test.method, test.path, result.Err)
+			continue
+		}
+		if result.StatusCode != test.statusCode || len(result.Content) != 0 {
+			t.Errorf("%s %s: expect an empty %d response, got %d %q", test.method, test.path, test.statusCode, result.StatusCode, result.Content)
+		}
+	}
+}
+
+func TestHTTPDownloaderDecompression(t *testing.T) {
+	content := []byte("hello, krawler")
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("Accept-Encoding")
+		if received == "" {
+			w.Write(content)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(encode(t, "gzip", content))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		disableDecompression bool
+		acceptEncoding       string
+		sent                 string
+		encoding             string
+		body                 []byte
+	}{
+		{false, "", acceptEncoding, "", content},
+		{false, "gzip", "gzip", "", content},
+		{true, "", "", "", content},
+		{true, "gzip", "gzip", "gzip", encode(t, "gzip", content)},
+	}
+
+	for _, test := range tests {
+		config := GetDefaultConfig()
+		config.Response.DisableDecompression = test.disableDecompression
+		downloader := NewHTTPDownloader(config)
+
+		task := &Task{URL: server.URL, Method: http.MethodGet}
+		if test.acceptEncoding != "" {
+			task.Headers = http.Header{"Accept-Encoding": {test.acceptEncoding}}
+		}
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(task, ch)
+		result := <-ch
+		downloader.Shutdown()
+
+		if result.Err != nil {
+			t.Errorf("%+v: %v", test, result.Err)
+			continue
+		}
+		if received != test.sent {
+			t.Errorf("%+v: expect Accept-Encoding %q, got %q", test, test.sent, received)
+		}
+		if encoding := result.Headers.Get("Content-Encoding"); encoding != test.encoding {
+			t.Errorf("%+v: expect Content-Encoding %q, got %q", test, test.encoding, encoding)
+		}
+		if !bytes.Equal(result.Content, test.body) {
+			t.Errorf("%+v: got body %q", test, result.Content)
+		}
+	}
+}
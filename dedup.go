@@ -0,0 +1,359 @@
+package krawler
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrForgetNotSupported indicates the deduplicator cannot forget a key
+var ErrForgetNotSupported = errors.New("deduplicator cannot forget keys")
+
+// Deduplicator remembers the keys of visited tasks. Queues check duplication of
+// tasks through a deduplicator, so any deduplicator works with any queue.
+type Deduplicator interface {
+	// Visit marks key as visited and reports whether it has been visited before.
+	// If ttl is positive, the key is forgotten after ttl, so that it can be visited
+	// again.
+	Visit(key string, ttl time.Duration) (bool, error)
+
+	// Forget removes a key so that it can be visited again.
+	Forget(key string) error
+}
+
+// MemoryDeduplicator is an exact deduplicator keeping every key in memory.
+type MemoryDeduplicator struct {
+	mutex sync.Mutex
+	// visited maps keys to their expiry time, which is zero if they never expire
+	visited map[string]time.Time
+	// expiries holds keys with a TTL in the order of their expiry time
+	expiries expiryHeap
+}
+
+// NewMemoryDeduplicator creates an in-memory deduplicator.
+func NewMemoryDeduplicator() *MemoryDeduplicator {
+	return &MemoryDeduplicator{visited: make(map[string]time.Time)}
+}
+
+// Visit implements Deduplicator#Visit
+func (d *MemoryDeduplicator) Visit(key string, ttl time.Duration) (bool, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	d.sweep(now)
+
+	if expiry, visited := d.visited[key]; visited && (expiry.IsZero() || now.Before(expiry)) {
+		return true, nil
+	}
+
+	expiry := time.Time{}
+	if ttl > 0 {
+		expiry = now.Add(ttl)
+		heap.Push(&d.expiries, expiringKey{key: key, expiry: expiry})
+	}
+	d.visited[key] = expiry
+	return false, nil
+}
+
+// sweep removes keys expired by now, so that keys visited only once do not stay
+// in memory. An entry of the heap is outdated if its key has been forgotten or
+// visited again since, and is dropped without touching the key.
+func (d *MemoryDeduplicator) sweep(now time.Time) {
+	for len(d.expiries) > 0 && !now.Before(d.expiries[0].expiry) {
+		entry := heap.Pop(&d.expiries).(expiringKey)
+		if expiry, visited := d.visited[entry.key]; visited && expiry.Equal(entry.expiry) {
+			delete(d.visited, entry.key)
+		}
+	}
+}
+
+type expiringKey struct {
+	key    string
+	expiry time.Time
+}
+
+// expiryHeap is a min-heap of keys ordered by expiry time.
+type expiryHeap []expiringKey
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expiry.Before(h[j].expiry) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiringKey)) }
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// Forget implements Deduplicator#Forget
+func (d *MemoryDeduplicator) Forget(key string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.visited, key)
+	return nil
+}
+
+// warnNoExpiry logs once that a deduplicator ignores TTLs.
+func warnNoExpiry(once *sync.Once, name string) {
+	once.Do(func() {
+		log.Warnf("%s cannot expire keys, tasks with a recrawl interval are never visited again", name)
+	})
+}
+
+// bloomLocations returns the k bit locations of a key in a filter of m bits, with
+// the double hashing scheme of Kirsch and Mitzenmacher.
+func bloomLocations(key string, k, m uint64) []uint64 {
+	h1 := fnv.New64a()
+	h1.Write([]byte(key))
+	h2 := fnv.New64()
+	h2.Write([]byte(key))
+
+	a, b := h1.Sum64(), h2.Sum64()|1
+	locations := make([]uint64, k)
+	for i := uint64(0); i < k; i++ {
+		locations[i] = (a + i*b) % m
+	}
+	return locations
+}
+
+// bloomSize returns the number of bits and hash functions of a Bloom filter
+// holding capacity keys at the false positive rate.
+func bloomSize(capacity uint64, falsePositiveRate float64) (m, k uint64) {
+	m = uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k = uint64(math.Ceil(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return m, k
+}
+
+type bloomFilter struct {
+	bits     []uint64
+	m, k     uint64
+	capacity uint64
+	count    uint64
+}
+
+func newBloomFilter(capacity uint64, falsePositiveRate float64) *bloomFilter {
+	m, k := bloomSize(capacity, falsePositiveRate)
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k, capacity: capacity}
+}
+
+func (f *bloomFilter) contains(key string) bool {
+	for _, location := range bloomLocations(key, f.k, f.m) {
+		if f.bits[location/64]&(1<<(location%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(key string) {
+	for _, location := range bloomLocations(key, f.k, f.m) {
+		f.bits[location/64] |= 1 << (location % 64)
+	}
+	f.count++
+}
+
+// BloomDeduplicator is a scalable Bloom filter. It uses a fraction of the memory
+// of an exact deduplicator, at the cost of treating a few unvisited keys as
+// visited. When a filter is full, a filter twice as large with a tighter false
+// positive rate is added, so that the overall rate stays below the configured one
+// however many keys are visited. Keys can neither expire nor be forgotten.
+type BloomDeduplicator struct {
+	mutex             sync.Mutex
+	filters           []*bloomFilter
+	falsePositiveRate float64
+	warnOnce          sync.Once
+}
+
+// bloomTightening is the ratio of the false positive rates of successive filters.
+const bloomTightening = 0.5
+
+// NewBloomDeduplicator creates a scalable Bloom filter sized for initialCapacity
+// keys at first, with an overall falsePositiveRate such as 0.001.
+func NewBloomDeduplicator(initialCapacity uint64, falsePositiveRate float64) (*BloomDeduplicator, error) {
+	if initialCapacity == 0 {
+		return nil, fmt.Errorf("capacity of bloom filter must be positive")
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, fmt.Errorf("%v is invalid for false positive rate of bloom filter", falsePositiveRate)
+	}
+
+	// the rates of the filters sum up to falsePositiveRate
+	rate := falsePositiveRate * (1 - bloomTightening)
+	return &BloomDeduplicator{
+		filters:           []*bloomFilter{newBloomFilter(initialCapacity, rate)},
+		falsePositiveRate: rate,
+	}, nil
+}
+
+// Visit implements Deduplicator#Visit
+func (d *BloomDeduplicator) Visit(key string, ttl time.Duration) (bool, error) {
+	if ttl > 0 {
+		warnNoExpiry(&d.warnOnce, "BloomDeduplicator")
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, filter := range d.filters {
+		if filter.contains(key) {
+			return true, nil
+		}
+	}
+
+	last := d.filters[len(d.filters)-1]
+	if last.count >= last.capacity {
+		rate := d.falsePositiveRate * math.Pow(bloomTightening, float64(len(d.filters)))
+		last = newBloomFilter(last.capacity*2, rate)
+		d.filters = append(d.filters, last)
+	}
+	last.add(key)
+	return false, nil
+}
+
+// Forget implements Deduplicator#Forget. It always fails because a key cannot be
+// removed from a Bloom filter.
+func (d *BloomDeduplicator) Forget(key string) error {
+	return ErrForgetNotSupported
+}
+
+// redisScriptBloomVisit sets the bits of a key and returns 1 if all of them were set.
+// KEYS = bitmap
+// ARGV = locations...
+var redisScriptBloomVisit = redis.NewScript(`
+local visited = 1
+for i = 1, #ARGV do
+	if redis.call('SETBIT', KEYS[1], ARGV[i], 1) == 0 then
+		visited = 0
+	end
+end
+return visited`)
+
+// RedisBloomDeduplicator is a Bloom filter stored in a redis bitmap, so that it can
+// be shared by workers. Unlike BloomDeduplicator it has a fixed size, and the false
+// positive rate rises once more than capacity keys are visited. Keys can neither
+// expire nor be forgotten.
+type RedisBloomDeduplicator struct {
+	redis    *redis.Client
+	key      string
+	m, k     uint64
+	warnOnce sync.Once
+}
+
+// redisMaxBits is the maximum size of a redis bitmap.
+const redisMaxBits = 1 << 32
+
+// NewRedisBloomDeduplicator creates a Bloom filter in redis sized for capacity keys
+// at falsePositiveRate.
+func NewRedisBloomDeduplicator(id string, redisOptions *redis.Options, capacity uint64, falsePositiveRate float64) (*RedisBloomDeduplicator, error) {
+	if capacity == 0 {
+		return nil, fmt.Errorf("capacity of bloom filter must be positive")
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, fmt.Errorf("%v is invalid for false positive rate of bloom filter", falsePositiveRate)
+	}
+
+	m, k := bloomSize(capacity, falsePositiveRate)
+	if m > redisMaxBits {
+		return nil, fmt.Errorf("bloom filter of %d bits exceeds the maximum size of a redis bitmap", m)
+	}
+
+	return &RedisBloomDeduplicator{
+		redis: redis.NewClient(redisOptions),
+		key:   fmt.Sprintf("{krawler:%s}:bloom", id),
+		m:     m,
+		k:     k,
+	}, nil
+}
+
+// Visit implements Deduplicator#Visit
+func (d *RedisBloomDeduplicator) Visit(key string, ttl time.Duration) (bool, error) {
+	if ttl > 0 {
+		warnNoExpiry(&d.warnOnce, "RedisBloomDeduplicator")
+	}
+
+	locations := bloomLocations(key, d.k, d.m)
+	args := make([]interface{}, len(locations))
+	for i, location := range locations {
+		args[i] = location
+	}
+
+	visited, err := redisScriptBloomVisit.Run(d.redis, []string{d.key}, args...).Int64()
+	if err != nil {
+		return false, fmt.Errorf("fail to check bloom filter, reason: %v", err)
+	}
+	return visited == 1, nil
+}
+
+// Forget implements Deduplicator#Forget. It always fails because a key cannot be
+// removed from a Bloom filter.
+func (d *RedisBloomDeduplicator) Forget(key string) error {
+	return ErrForgetNotSupported
+}
+
+// Close closes the redis connection.
+func (d *RedisBloomDeduplicator) Close() error {
+	return d.redis.Close()
+}
+
+// RedisSetDeduplicator is an exact deduplicator storing a redis key per visited
+// key. The keys expire after a TTL, so that memory of redis is bounded by the
+// number of keys visited within the TTL.
+type RedisSetDeduplicator struct {
+	redis     *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+}
+
+// NewRedisSetDeduplicator creates an exact deduplicator in redis. Visited keys are
+// forgotten after ttl unless it is zero. The TTL given to Visit takes precedence.
+func NewRedisSetDeduplicator(id string, redisOptions *redis.Options, ttl time.Duration) *RedisSetDeduplicator {
+	return newRedisSetDeduplicator(redis.NewClient(redisOptions), id, ttl)
+}
+
+func newRedisSetDeduplicator(client *redis.Client, id string, ttl time.Duration) *RedisSetDeduplicator {
+	return &RedisSetDeduplicator{
+		redis:     client,
+		keyPrefix: fmt.Sprintf("{krawler:%s}:dup:", id),
+		ttl:       ttl,
+	}
+}
+
+// Visit implements Deduplicator#Visit
+func (d *RedisSetDeduplicator) Visit(key string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		ttl = d.ttl
+	}
+
+	ok, err := d.redis.SetNX(d.keyPrefix+key, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check duplication for %s, reason: %v", key, err)
+	}
+	return !ok, nil
+}
+
+// Forget implements Deduplicator#Forget
+func (d *RedisSetDeduplicator) Forget(key string) error {
+	if err := d.redis.Del(d.keyPrefix + key).Err(); err != nil {
+		return fmt.Errorf("failed to forget %s, reason: %v", key, err)
+	}
+	return nil
+}
+
+// Close closes the redis connection.
+func (d *RedisSetDeduplicator) Close() error {
+	return d.redis.Close()
+}
@@ -0,0 +1,166 @@
+package krawler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestBloomSize(t *testing.T) {
+	tests := []struct {
+		capacity          uint64
+		falsePositiveRate float64
+		m, k              uint64
+	}{
+		{1000, 0.01, 9586, 7},
+		{1000, 0.001, 14378, 10},
+		{1, 0.5, 2, 2},
+		{1000000, 0.0001, 19170117, 14},
+	}
+
+	for _, test := range tests {
+		m, k := bloomSize(test.capacity, test.falsePositiveRate)
+		if m != test.m || k != test.k {
+			t.Errorf("%d keys at %v: expect %d bits and %d hashes, got %d and %d",
+				test.capacity, test.falsePositiveRate, test.m, test.k, m, k)
+		}
+	}
+}
+
+func TestBloomDeduplicator(t *testing.T) {
+	const keys = 5000
+	d, err := NewBloomDeduplicator(100, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	falsePositives := 0
+	for i := 0; i < keys; i++ {
+		visited, err := d.Visit(fmt.Sprintf("key-%d", i), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visited {
+			falsePositives++
+		}
+	}
+	if len(d.filters) < 2 {
+		t.Errorf("expect the filter to scale beyond its initial capacity, got %d filters", len(d.filters))
+	}
+	if rate := float64(falsePositives) / keys; rate > 0.01 {
+		t.Errorf("expect a false positive rate below 0.01, got %v", rate)
+	}
+
+	for i := 0; i < keys; i++ {
+		if visited, _ := d.Visit(fmt.Sprintf("key-%d", i), 0); !visited {
+			t.Fatalf("key-%d: expect visited", i)
+		}
+	}
+
+	if err := d.Forget("key-0"); err != ErrForgetNotSupported {
+		t.Errorf("expect %v, got %v", ErrForgetNotSupported, err)
+	}
+}
+
+func TestNewBloomDeduplicator(t *testing.T) {
+	tests := []struct {
+		capacity          uint64
+		falsePositiveRate float64
+		fail              bool
+	}{
+		{100, 0.01, false},
+		{0, 0.01, true},
+		{100, 0, true},
+		{100, 1, true},
+		{100, -0.1, true},
+	}
+
+	for _, test := range tests {
+		_, err := NewBloomDeduplicator(test.capacity, test.falsePositiveRate)
+		if (err != nil) != test.fail {
+			t.Errorf("%d keys at %v: expect failure %v, got error %v", test.capacity, test.falsePositiveRate, test.fail, err)
+		}
+	}
+}
+
+func TestNewRedisBloomDeduplicatorTooLarge(t *testing.T) {
+	if _, err := NewRedisBloomDeduplicator("test", &redis.Options{}, 1<<32, 0.001); err == nil {
+		t.Error("expect a filter exceeding a redis bitmap to be rejected")
+	}
+}
+
+func TestMemoryDeduplicator(t *testing.T) {
+	d := NewMemoryDeduplicator()
+	steps := []struct {
+		action  string
+		key     string
+		ttl     time.Duration
+		visited bool
+	}{
+		{"visit", "a", 0, false},
+		{"visit", "a", 0, true},
+		{"visit", "a", time.Hour, true},
+		{"forget", "a", 0, false},
+		{"visit", "a", 0, false},
+		{"visit", "b", 20 * time.Millisecond, false},
+		{"visit", "b", 0, true},
+		{"sleep", "", 30 * time.Millisecond, false},
+		{"visit", "b", time.Hour, false},
+		{"visit", "b", 0, true},
+		{"forget", "missing", 0, false},
+	}
+
+	for i, step := range steps {
+		switch step.action {
+		case "visit":
+			visited, err := d.Visit(step.key, step.ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if visited != step.visited {
+				t.Errorf("step %d: expect %s visited %v, got %v", i, step.key, step.visited, visited)
+			}
+		case "forget":
+			if err := d.Forget(step.key); err != nil {
+				t.Errorf("step %d: fail to forget %s, reason: %v", i, step.key, err)
+			}
+		case "sleep":
+			time.Sleep(step.ttl)
+		}
+	}
+}
+
+func TestMemoryDeduplicatorSweep(t *testing.T) {
+	d := NewMemoryDeduplicator()
+	start := time.Now()
+
+	d.Visit("once", time.Minute)
+	d.Visit("forever", 0)
+	d.Visit("forgotten", time.Minute)
+	d.Forget("forgotten")
+	d.Visit("forgotten", 0)
+
+	// a key forgotten and visited again gets a new expiry, and the outdated heap
+	// entry must not remove it
+	d.Visit("again", time.Minute)
+	d.Forget("again")
+	d.Visit("again", time.Hour)
+
+	d.sweep(start.Add(2 * time.Minute))
+
+	for key, kept := range map[string]bool{"once": false, "forever": true, "forgotten": true, "again": true} {
+		if _, ok := d.visited[key]; ok != kept {
+			t.Errorf("%s: expect kept %v, got %v", key, kept, ok)
+		}
+	}
+	if len(d.expiries) != 1 || d.expiries[0].key != "again" {
+		t.Errorf("expect only the expiry of again to be left, got %v", d.expiries)
+	}
+
+	d.sweep(start.Add(2 * time.Hour))
+	if len(d.visited) != 2 || len(d.expiries) != 0 {
+		t.Errorf("expect only keys without TTL to be left, got %v and %v", d.visited, d.expiries)
+	}
+}
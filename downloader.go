@@ -1,19 +1,121 @@
 package krawler
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"time"
 )
 
 // DownloadResult defines how download result should be organized
 type DownloadResult struct {
 	StatusCode int
-	Content    []byte
 	Headers    http.Header
 	Cookies    []*http.Cookie
 
+	// Content is the body of the response. It is nil if the body is streamed into
+	// the file at BodyPath.
+	Content []byte
+
+	// BodyPath is the path of the temporary file holding a streamed body. The file
+	// is removed after the result is processed.
+	BodyPath string
+
+	// Truncated indicates the body is cut at the maximum body size.
+	Truncated bool
+
+	// CacheHit indicates the content is served from the HTTP cache, either because
+	// the cached response is fresh or because the server answers 304 Not Modified.
+	// In both cases the content is unchanged since it was cached.
+	CacheHit bool
+
+	// Charset is the detected charset of the body, which is used by Text to decode
+	// the body into UTF-8.
+	Charset string
+
+	// URL is the final URL of the content after following redirects.
+	URL string
+
+	// Redirects records the redirects followed to reach URL, in order.
+	Redirects []Redirect
+
+	// RemoteAddr is the address of the server that served the content.
+	RemoteAddr string
+
+	// Proxy is the proxy the content is downloaded through, with its password
+	// redacted.
+	Proxy string
+
+	// Timings is the time spent in every phase of the request. It is nil if no
+	// request is sent, such as when the content is served from the cache.
+	Timings *Timings
+
 	Err  error
 	Task *Task
+
+	parsed *parsedContent
+
+	// skippedMiddlewares is the number of middlewares whose BeforeDownload is not
+	// called because an earlier one fails, counted from the last middleware.
+	skippedMiddlewares int
+}
+
+// Body returns a reader of the body, no matter whether the body is streamed into a
+// file or not. The reader should be closed after use.
+func (r *DownloadResult) Body() (io.ReadCloser, error) {
+	if r.BodyPath == "" {
+		return ioutil.NopCloser(bytes.NewReader(r.Content)), nil
+	}
+	return os.Open(r.BodyPath)
+}
+
+// Bytes returns the whole body, reading it from BodyPath if the body is streamed
+// into a file.
+func (r *DownloadResult) Bytes() ([]byte, error) {
+	if r.BodyPath == "" {
+		return r.Content, nil
+	}
+	return ioutil.ReadFile(r.BodyPath)
+}
+
+// Release removes the temporary file of a streamed body.
+func (r *DownloadResult) Release() {
+	if r.BodyPath != "" {
+		os.Remove(r.BodyPath)
+	}
+}
+
+// Redirect records a response that redirects the request to another URL.
+type Redirect struct {
+	URL        string
+	StatusCode int
+}
+
+// resultURL returns the URL that the content of a result is downloaded from.
+func resultURL(result *DownloadResult) (*url.URL, error) {
+	rawURL := result.URL
+	if rawURL == "" {
+		rawURL = result.Task.URL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s, reason: %v", rawURL, err)
+	}
+	return u, nil
+}
+
+// deliver sends the result of a downloader which downloads synchronously, without
+// blocking the caller of Download.
+func deliver(result *DownloadResult, chResult chan<- *DownloadResult) {
+	go func() {
+		chResult <- result
+	}()
 }
 
 // Downloader define a downloader
@@ -34,4 +136,36 @@ var (
 	// ErrDownloaderShuttingDown indicates the downloader is currently shutting down
 	// and no new task is allow to be scheduled
 	ErrDownloaderShuttingDown = errors.New("the downloader is currently shutting down")
+
+	// ErrBodyTooLarge indicates the body of the response exceeds the maximum body size
+	ErrBodyTooLarge = errors.New("body is too large")
+
+	// ErrContentTypeNotAllowed indicates the content type of the response is not allowed
+	ErrContentTypeNotAllowed = errors.New("content type is not allowed")
 )
+
+// PostponeError asks the engine to put a task back into the queue after Delay. A
+// postponed task does not count as a retry, unless Failure is set.
+type PostponeError struct {
+	Reason string
+	Delay  time.Duration
+
+	// Failure marks a postponement caused by a failed response, such as 429 Too
+	// Many Requests. It counts as a retry, so that the task is dropped after too
+	// many of them, and as a failure of the host for circuit breakers.
+	Failure bool
+}
+
+func (e *PostponeError) Error() string {
+	return fmt.Sprintf("postponed for %v because %s", e.Delay, e.Reason)
+}
+
+// PermanentError fails a task without retrying it, because the download would fail
+// in the same way again, such as for an invalid URL.
+type PermanentError struct {
+	Reason string
+}
+
+func (e *PermanentError) Error() string {
+	return e.Reason
+}
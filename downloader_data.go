@@ -0,0 +1,87 @@
+package krawler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DataURLDownloader decodes data: URLs defined by RFC 2397.
+type DataURLDownloader struct {
+}
+
+// NewDataURLDownloader creates a data URL downloader.
+func NewDataURLDownloader() *DataURLDownloader {
+	return &DataURLDownloader{}
+}
+
+// Download implements Downloader#Download
+func (d *DataURLDownloader) Download(task *Task, chResult chan<- *DownloadResult) {
+	task.Meta.DownloadStartTime = time.Now()
+	result := &DownloadResult{Task: task, URL: task.URL}
+
+	contentType, content, err := parseDataURL(task.URL)
+	if err != nil {
+		result.Err = err
+	} else {
+		result.StatusCode = http.StatusOK
+		result.Headers = http.Header{"Content-Type": {contentType}}
+		result.Content = content
+		detectResultCharset(result)
+	}
+
+	task.Meta.DownloadFinishTime = time.Now()
+	deliver(result, chResult)
+}
+
+// parseDataURL returns the media type and the data of a data URL.
+func parseDataURL(rawURL string) (string, []byte, error) {
+	if !strings.HasPrefix(strings.ToLower(rawURL), "data:") {
+		return "", nil, fmt.Errorf("invalid data url %s", rawURL)
+	}
+
+	comma := strings.Index(rawURL, ",")
+	if comma < 0 {
+		return "", nil, fmt.Errorf("invalid data url %s: missing comma", rawURL)
+	}
+	header, data := rawURL[len("data:"):comma], rawURL[comma+1:]
+
+	isBase64 := false
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		isBase64 = true
+		header = header[:len(header)-len(";base64")]
+	}
+
+	contentType := header
+	if contentType == "" || strings.HasPrefix(contentType, ";") {
+		contentType = "text/plain" + contentType
+		if !strings.Contains(contentType, "charset=") {
+			contentType += ";charset=US-ASCII"
+		}
+	}
+
+	decoded, err := url.PathUnescape(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid data url, reason: %v", err)
+	}
+	if !isBase64 {
+		return contentType, []byte(decoded), nil
+	}
+
+	// some encoders omit the padding
+	content, err := base64.StdEncoding.DecodeString(decoded)
+	if err != nil {
+		content, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(decoded, "="))
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid base64 data url, reason: %v", err)
+	}
+	return contentType, content, nil
+}
+
+// Shutdown implements Downloader#Shutdown
+func (d *DataURLDownloader) Shutdown() {
+}
@@ -0,0 +1,40 @@
+package krawler
+
+import "testing"
+
+func TestParseDataURL(t *testing.T) {
+	tests := []struct {
+		url         string
+		contentType string
+		content     string
+		fail        bool
+	}{
+		{"data:,hello", "text/plain;charset=US-ASCII", "hello", false},
+		{"DATA:,hello", "text/plain;charset=US-ASCII", "hello", false},
+		{"data:;charset=utf-8,caf%C3%A9", "text/plain;charset=utf-8", "café", false},
+		{"data:text/html,%3Cp%3Ehi%3C%2Fp%3E", "text/html", "<p>hi</p>", false},
+		{"data:text/plain;base64,aGVsbG8=", "text/plain", "hello", false},
+		{"data:text/plain;BASE64,aGVsbG8", "text/plain", "hello", false},
+		{"data:;base64,aGVsbG8=", "text/plain;charset=US-ASCII", "hello", false},
+		{"data:text/plain,a,b", "text/plain", "a,b", false},
+		{"data:text/plain,", "text/plain", "", false},
+		{"data:text/plain", "", "", true},
+		{"http://example.com/,x", "", "", true},
+		{"data:;base64,!!!", "", "", true},
+		{"data:,%zz", "", "", true},
+	}
+
+	for _, test := range tests {
+		contentType, content, err := parseDataURL(test.url)
+		if (err != nil) != test.fail {
+			t.Errorf("%s: expect failure %v, got error %v", test.url, test.fail, err)
+			continue
+		}
+		if test.fail {
+			continue
+		}
+		if contentType != test.contentType || string(content) != test.content {
+			t.Errorf("%s: expect %q %q, got %q %q", test.url, test.contentType, test.content, contentType, content)
+		}
+	}
+}
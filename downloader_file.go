@@ -0,0 +1,217 @@
+package krawler
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FileDownloader reads file:// URLs from the local file system, which is useful
+// for crawling local HTML dumps. A directory is served as an HTML page linking to
+// its entries, so that it can be crawled like an index page.
+type FileDownloader struct {
+	root     string
+	maxSize  int64
+	truncate bool
+}
+
+// FileDownloaderOption customizes a FileDownloader.
+type FileDownloaderOption func(*FileDownloader)
+
+// WithFileSizeLimit limits the size of files in bytes like
+// ResponseConfig.MaxBodySize. A larger file fails with ErrBodyTooLarge, or is
+// truncated if truncate is set. Zero means no limit.
+func WithFileSizeLimit(maxSize int64, truncate bool) FileDownloaderOption {
+	return func(d *FileDownloader) {
+		d.maxSize = maxSize
+		d.truncate = truncate
+	}
+}
+
+// NewFileDownloader creates a file downloader. If root is not empty, files outside
+// of root are not allowed to be read, even through symbolic links.
+func NewFileDownloader(root string, options ...FileDownloaderOption) (*FileDownloader, error) {
+	d := &FileDownloader{}
+	for _, option := range options {
+		option(d)
+	}
+	if root == "" {
+		return d, nil
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err == nil {
+		absRoot, err = filepath.EvalSymlinks(absRoot)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid root %s, reason: %v", root, err)
+	}
+	d.root = absRoot
+	return d, nil
+}
+
+// Download implements Downloader#Download. A task whose URL is not a local file URL
+// fails with a PermanentError, while a missing file is answered with 404.
+func (d *FileDownloader) Download(task *Task, chResult chan<- *DownloadResult) {
+	task.Meta.DownloadStartTime = time.Now()
+	result := d.read(task)
+	task.Meta.DownloadFinishTime = time.Now()
+	deliver(result, chResult)
+}
+
+func (d *FileDownloader) read(task *Task) *DownloadResult {
+	result := &DownloadResult{Task: task, URL: task.URL, Headers: make(http.Header)}
+
+	u, err := url.Parse(task.URL)
+	if err != nil || u.Scheme != "file" {
+		result.Err = &PermanentError{Reason: fmt.Sprintf("invalid file url %s", task.URL)}
+		return result
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		result.Err = &PermanentError{Reason: fmt.Sprintf("file url %s on a remote host is not supported", task.URL)}
+		return result
+	}
+
+	path := filepath.FromSlash(u.Path)
+	if d.root != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(d.root, path)
+		}
+		path = filepath.Clean(path)
+		if !d.inRoot(path) {
+			result.StatusCode = http.StatusForbidden
+			return result
+		}
+
+		// a symbolic link inside the root may point out of it
+		var target string
+		target, err = filepath.EvalSymlinks(path)
+		if err == nil && !d.inRoot(target) {
+			result.StatusCode = http.StatusForbidden
+			return result
+		}
+		if err == nil {
+			path = target
+		}
+	}
+
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(path)
+	}
+	if os.IsNotExist(err) || isNotDir(err) {
+		result.StatusCode = http.StatusNotFound
+		return result
+	} else if os.IsPermission(err) {
+		result.StatusCode = http.StatusForbidden
+		return result
+	} else if err != nil {
+		result.Err = fmt.Errorf("stat file %s failed, reason: %v", path, err)
+		return result
+	}
+
+	if info.IsDir() {
+		// relative links of the index resolve against the URL of the directory
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+			result.URL = u.String()
+		}
+		result.Content, err = directoryIndex(path)
+		result.Headers.Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		err = d.readFile(path, result)
+		contentType := mime.TypeByExtension(filepath.Ext(path))
+		if contentType == "" {
+			contentType = http.DetectContentType(result.Content)
+		}
+		result.Headers.Set("Content-Type", contentType)
+	}
+	if err == ErrBodyTooLarge {
+		result.Err = err
+		return result
+	} else if err != nil {
+		result.Err = fmt.Errorf("read %s failed, reason: %v", path, err)
+		return result
+	}
+
+	result.StatusCode = http.StatusOK
+	result.Headers.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	detectResultCharset(result)
+	return result
+}
+
+// inRoot tells whether a clean absolute path is the root or inside it.
+func (d *FileDownloader) inRoot(path string) bool {
+	return path == d.root || strings.HasPrefix(path, d.root+string(filepath.Separator))
+}
+
+// isNotDir tells whether err is caused by a path going through a regular file as
+// if it was a directory, which means the file does not exist.
+func isNotDir(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == syscall.ENOTDIR
+}
+
+// readFile reads the content of a file into result within the size limit.
+func (d *FileDownloader) readFile(path string, result *DownloadResult) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if d.maxSize <= 0 {
+		result.Content, err = ioutil.ReadAll(file)
+		return err
+	}
+
+	result.Content, err = ioutil.ReadAll(io.LimitReader(file, d.maxSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(result.Content)) > d.maxSize {
+		if !d.truncate {
+			result.Content = nil
+			return ErrBodyTooLarge
+		}
+		result.Content = result.Content[:d.maxSize]
+		result.Truncated = true
+	}
+	return nil
+}
+
+// directoryIndex renders an HTML page linking to the entries of a directory.
+func directoryIndex(path string) ([]byte, error) {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := new(strings.Builder)
+	builder.WriteString("<!DOCTYPE html>\n<html><body><ul>\n")
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		link := (&url.URL{Path: name}).String()
+		fmt.Fprintf(builder, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(link), html.EscapeString(name))
+	}
+	builder.WriteString("</ul></body></html>\n")
+	return []byte(builder.String()), nil
+}
+
+// Shutdown implements Downloader#Shutdown
+func (d *FileDownloader) Shutdown() {
+}
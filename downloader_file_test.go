@@ -0,0 +1,160 @@
+package krawler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileDownloader(t *testing.T) {
+	root, err := ioutil.TempDir("", "krawler-file-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	outside, err := ioutil.TempDir("", "krawler-outside-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	os.MkdirAll(filepath.Join(root, "pages", "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "pages", "index.html"), []byte("<p>index</p>"), 0644)
+	ioutil.WriteFile(filepath.Join(outside, "secret.html"), []byte("<p>secret</p>"), 0644)
+	os.Symlink(filepath.Join(root, "pages", "index.html"), filepath.Join(root, "inside.html"))
+	os.Symlink(filepath.Join(outside, "secret.html"), filepath.Join(root, "secret.html"))
+	os.Symlink(outside, filepath.Join(root, "outside"))
+
+	downloader, err := NewFileDownloader(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "file://" + filepath.ToSlash(root)
+
+	tests := []struct {
+		url        string
+		statusCode int
+		finalURL   string
+		content    string
+	}{
+		{base + "/pages/index.html", http.StatusOK, base + "/pages/index.html", "<p>index</p>"},
+		{base + "/pages", http.StatusOK, base + "/pages/", `<a href="index.html">`},
+		{base + "/pages/", http.StatusOK, base + "/pages/", `<a href="sub/">`},
+		{base + "/missing.html", http.StatusNotFound, base + "/missing.html", ""},
+		{base + "/pages/index.html/sub", http.StatusNotFound, base + "/pages/index.html/sub", ""},
+		{"file:///etc/passwd", http.StatusForbidden, "file:///etc/passwd", ""},
+		{base + "/inside.html", http.StatusOK, base + "/inside.html", "<p>index</p>"},
+		{base + "/secret.html", http.StatusForbidden, base + "/secret.html", ""},
+		{base + "/outside/secret.html", http.StatusForbidden, base + "/outside/secret.html", ""},
+	}
+
+	for _, test := range tests {
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: test.url, Method: http.MethodGet}, ch)
+		result := <-ch
+
+		if result.Err != nil {
+			t.Errorf("%s: %v", test.url, result.Err)
+			continue
+		}
+		if result.StatusCode != test.statusCode || result.URL != test.finalURL {
+			t.Errorf("%s: expect %d %s, got %d %s", test.url, test.statusCode, test.finalURL, result.StatusCode, result.URL)
+		}
+		if !strings.Contains(string(result.Content), test.content) {
+			t.Errorf("%s: expect content containing %q, got %q", test.url, test.content, result.Content)
+		}
+	}
+}
+
+func TestFileDownloaderErrors(t *testing.T) {
+	root, err := ioutil.TempDir("", "krawler-file-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	ioutil.WriteFile(filepath.Join(root, "page.html"), []byte("0123456789"), 0644)
+	page := "file://" + filepath.ToSlash(root) + "/page.html"
+
+	tests := []struct {
+		url       string
+		options   []FileDownloaderOption
+		content   string
+		truncated bool
+		err       string
+		permanent bool
+	}{
+		{page, []FileDownloaderOption{WithFileSizeLimit(10, false)}, "0123456789", false, "", false},
+		{page, []FileDownloaderOption{WithFileSizeLimit(4, true)}, "0123", true, "", false},
+		{page, []FileDownloaderOption{WithFileSizeLimit(4, false)}, "", false, ErrBodyTooLarge.Error(), true},
+		{"http://example.com/page.html", nil, "", false, "invalid file url", true},
+		{"file://example.com/page.html", nil, "", false, "remote host is not supported", true},
+	}
+
+	for _, test := range tests {
+		downloader, err := NewFileDownloader(root, test.options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: test.url, Method: http.MethodGet}, ch)
+		result := <-ch
+
+		if test.err != "" {
+			if result.Err == nil || !strings.Contains(result.Err.Error(), test.err) {
+				t.Errorf("%s: expect error %q, got %v", test.url, test.err, result.Err)
+			} else if isPermanentDownloadError(result.Err) != test.permanent {
+				t.Errorf("%s: expect permanent %v, got %v", test.url, test.permanent, !test.permanent)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("%s: %v", test.url, result.Err)
+			continue
+		}
+		if string(result.Content) != test.content || result.Truncated != test.truncated {
+			t.Errorf("%s: expect %q truncated %v, got %q %v", test.url, test.content, test.truncated, result.Content, result.Truncated)
+		}
+	}
+}
+
+func TestRouterDownloader(t *testing.T) {
+	config := GetDefaultConfig()
+	config.Response.MaxBodySize = 4
+	router := NewDefaultRouterDownloader(config)
+	defer router.Shutdown()
+
+	tests := []struct {
+		url     string
+		content string
+		err     string
+	}{
+		{"data:,hello", "hello", ""},
+		{"data:,hello, krawler", "hello, krawler", ""},
+		{"ftp://example.com/file", "", "no downloader is routed for scheme ftp"},
+		{"http://[::1", "", "invalid url"},
+		{"file:///" + strings.Repeat("x", 8), "", ""},
+	}
+
+	for _, test := range tests {
+		ch := make(chan *DownloadResult, 1)
+		router.Download(&Task{URL: test.url, Method: http.MethodGet}, ch)
+		result := <-ch
+
+		if test.err != "" {
+			if result.Err == nil || !strings.Contains(result.Err.Error(), test.err) || !isPermanentDownloadError(result.Err) {
+				t.Errorf("%s: expect permanent error %q, got %v", test.url, test.err, result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("%s: %v", test.url, result.Err)
+		} else if string(result.Content) != test.content {
+			t.Errorf("%s: expect %q, got %q", test.url, test.content, result.Content)
+		}
+	}
+}
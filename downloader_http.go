@@ -2,33 +2,236 @@ package krawler
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // HTTPDownloader implements a simple http downloader
 type HTTPDownloader struct {
-	userAgent      string
-	timeout        time.Duration
+	headers        *headerBuilder
+	timeouts       Timeouts
 	followRedirect bool
+	maxRedirects   int
 	concurrency    int
 	running        chan int
 	shuttingDown   bool
+	client         *http.Client
+	response       ResponseConfig
+	proxyPool      *ProxyPool
+	cookieJar      *CookieJar
+	cache          *HTTPCache
+	throttle       *autoThrottle
+	timingStats    timingStats
+	hostAuths      map[string]Authenticator
+	processorAuths map[string]Authenticator
+	signers        map[string]Authenticator
+	authClient     *http.Client
+	ctx            context.Context
+	cancel         context.CancelFunc
 }
 
-// NewHTTPDownloader returns a HTTP Downloader objects
-func NewHTTPDownloader(config *Config) *HTTPDownloader {
+// defaultExpectContinueTimeout is the time to wait for a server's first response
+// headers after fully writing the request headers if the request has an
+// "Expect: 100-continue" header.
+const defaultExpectContinueTimeout = 1 * time.Second
+
+// NewHTTPDownloader returns a HTTP Downloader objects. Every downloader owns its
+// http client, which is configured by config.Transport and options.
+func NewHTTPDownloader(config *Config, options ...HTTPDownloaderOption) *HTTPDownloader {
 	d := new(HTTPDownloader)
-	d.timeout = config.Request.Timeout
-	d.userAgent = config.Request.UserAgent
+	d.timeouts = Timeouts{
+		Connect:        config.Request.ConnectTimeout,
+		TLSHandshake:   config.Request.TLSHandshakeTimeout,
+		ResponseHeader: config.Request.ResponseHeaderTimeout,
+		Total:          config.Request.Timeout,
+	}
+	headers, err := newHeaderBuilder(config.Request, config.Headers)
+	if err != nil {
+		log.Fatalf("Fail to create header builder, reason: %v", err)
+	}
+	d.headers = headers
 	d.followRedirect = config.Request.FollowRedirect
+	d.maxRedirects = config.Request.MaxRedirects
+	d.response = config.Response
 	d.setConcurrency(config.Request.Concurrency)
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.client = &http.Client{CheckRedirect: d.checkRedirect}
+	d.hostAuths = make(map[string]Authenticator)
+	d.processorAuths = make(map[string]Authenticator)
+	d.signers = make(map[string]Authenticator)
+	if config.Throttle.Enabled {
+		d.throttle = newAutoThrottle(config.Throttle)
+	}
+
+	if len(config.ProxyPool.Proxies) > 0 || config.ProxyPool.File != "" {
+		pool, err := NewProxyPool(config.ProxyPool)
+		if err != nil {
+			log.Fatalf("Fail to create proxy pool, reason: %v", err)
+		}
+		d.proxyPool = pool
+	}
+
+	for _, option := range options {
+		option(d)
+	}
+
+	if d.client.Transport == nil {
+		transport, err := d.newTransport(config.Transport)
+		if err != nil {
+			log.Fatalf("Fail to create http transport, reason: %v", err)
+		}
+		d.client.Transport = transport
+	}
+	if transport, ok := d.client.Transport.(*http.Transport); ok && d.proxyPool != nil {
+		d.proxyPool.setTLSConfig(transport.TLSClientConfig)
+	}
+	d.authClient = &http.Client{Transport: d.client.Transport, Timeout: d.timeouts.Total}
+
 	return d
 }
 
+// downloadStateKey is the context key of the downloadState of a request.
+type downloadStateKey struct{}
+
+// downloadState collects information about a request while it is being sent.
+type downloadState struct {
+	redirects  []Redirect
+	remoteAddr string
+	proxy      *url.URL
+	task       *Task
+
+	timeouts Timeouts
+	cancel   context.CancelFunc
+
+	mutex      sync.Mutex
+	timer      *time.Timer
+	timeoutErr error
+
+	timings     Timings
+	getConnAt   time.Time
+	dnsStartAt  time.Time
+	connectAt   time.Time
+	handshakeAt time.Time
+}
+
+// connected tells whether a connection has been obtained. Through a proxy, errors
+// before that are failures of the proxy, while errors after that, like timeouts of
+// a slow site, are not.
+func (s *downloadState) connected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.remoteAddr != ""
+}
+
+// markPhase records the start of a phase, unless a parallel attempt of the phase
+// has already started.
+func (s *downloadState) markPhase(start *time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if start.IsZero() {
+		*start = time.Now()
+	}
+}
+
+// endPhase adds the time since the start of a phase into its duration. Phases
+// of every redirect are summed up.
+func (s *downloadState) endPhase(start *time.Time, duration *time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !start.IsZero() {
+		*duration += time.Since(*start)
+		*start = time.Time{}
+	}
+}
+
+// startTimer aborts the request if the current phase does not finish in time.
+// A zero timeout disables the check.
+func (s *downloadState) startTimer(phase string, timeout time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if timeout <= 0 {
+		return
+	}
+
+	s.timer = time.AfterFunc(timeout, func() {
+		s.mutex.Lock()
+		s.timeoutErr = fmt.Errorf("%s timeout after %v", phase, timeout)
+		s.mutex.Unlock()
+		s.cancel()
+	})
+}
+
+func (s *downloadState) stopTimer() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+func (s *downloadState) timeoutError() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.timeoutErr
+}
+
+func (d *HTTPDownloader) checkRedirect(request *http.Request, via []*http.Request) error {
+	if !d.followRedirect {
+		return http.ErrUseLastResponse
+	}
+
+	// via holds the requests sent so far, so it has n requests when following the
+	// nth redirect
+	if len(via) > d.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", d.maxRedirects)
+	}
+
+	if state, ok := request.Context().Value(downloadStateKey{}).(*downloadState); ok && request.Response != nil {
+		state.redirects = append(state.redirects, Redirect{
+			URL:        via[len(via)-1].URL.String(),
+			StatusCode: request.Response.StatusCode,
+		})
+
+		if d.cookieJar != nil && state.task.Session != "" {
+			d.cookieJar.SetCookies(state.task.Session, via[len(via)-1].URL, request.Response.Cookies())
+			request.Header.Del("Cookie")
+			// cookies of the task are not scoped to a domain, so they are only sent to
+			// the host of the task
+			d.addCookies(request, state.task, request.URL.Host == via[0].URL.Host)
+		}
+	}
+
+	// a signature covers the URL, so the redirected request is signed again
+	if signer := hostAuthenticator(d.signers, request.URL.Hostname()); signer != nil {
+		if err := signer.Authenticate(request, d.authClient); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (d *HTTPDownloader) setConcurrency(newConcurrency int) {
 	d.running = make(chan int, newConcurrency)
 	d.concurrency = newConcurrency
@@ -42,7 +245,7 @@ func (d *HTTPDownloader) finishTask() {
 	<-d.running
 }
 
-func (d *HTTPDownloader) doDownload(task *Task, chDoResult chan *DownloadResult) {
+func (d *HTTPDownloader) doDownload(task *Task) *DownloadResult {
 	task.Meta.DownloadStartTime = time.Now()
 	task.Meta.DownloadFinishTime = time.Time{}
 	defer func(finishTime *time.Time) {
@@ -61,62 +264,313 @@ func (d *HTTPDownloader) doDownload(task *Task, chDoResult chan *DownloadResult)
 	request, err := http.NewRequest(task.Method, task.URL, body)
 	if err != nil {
 		result.Err = fmt.Errorf("create request instance failed, reason: %v", err)
-		chDoResult <- result
-		return
+		return result
 	}
-	request.Header = make(http.Header)
-	request.Header["User-Agent"] = []string{d.userAgent}
-	for field, value := range task.Headers {
-		request.Header[field] = value
+	request.Header = d.headers.build(request.URL.Hostname(), task)
+	if !d.response.DisableDecompression && request.Header.Get("Accept-Encoding") == "" {
+		request.Header.Set("Accept-Encoding", acceptEncoding)
 	}
-	for _, cookie := range task.Cookies {
-		request.AddCookie(cookie)
+	d.addCookies(request, task, true)
+
+	// credentials are added before the cache lookup, so that responses are not
+	// shared between requests with different credentials
+	auth := d.authenticator(request.URL.Hostname(), task)
+	if auth != nil {
+		if err := auth.Authenticate(request, d.authClient); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	var cached *CachedResponse
+	var cacheKey string
+	if d.cache != nil {
+		var fresh bool
+		if cacheKey, cached, fresh = d.cache.lookup(request, task); fresh {
+			cached.fill(result)
+			detectResultCharset(result)
+			return result
+		}
 	}
 
-	response, err := http.DefaultClient.Do(request)
+	if d.throttle != nil {
+		wait, err := d.throttle.reserve(request.URL.Hostname())
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		if wait > 0 {
+			// a waiting task gives its slot up, so that a throttled host does not hold
+			// back requests to other hosts
+			d.finishTask()
+			err = d.throttle.sleep(d.ctx, wait)
+			d.startTask()
+			if err != nil {
+				result.Err = err
+				return result
+			}
+		}
+		// the latency fed back to the throttle excludes the wait
+		task.Meta.DownloadStartTime = time.Now()
+	}
+
+	if signer := hostAuthenticator(d.signers, request.URL.Hostname()); signer != nil {
+		if err := signer.Authenticate(request, d.authClient); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	timeouts := d.timeouts.merge(task.Timeouts)
+	ctx, cancel := context.WithTimeout(d.ctx, timeouts.Total)
+	defer cancel()
+
+	state := &downloadState{timeouts: timeouts, cancel: cancel, task: task}
+	defer state.stopTimer()
+
+	if d.proxyPool != nil {
+		state.proxy, err = d.proxyPool.Pick(request.URL.Hostname(), task.Meta.Proxy)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Proxy = redactURL(state.proxy)
+		task.Meta.Proxy = result.Proxy
+	}
+
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			state.markPhase(&state.getConnAt)
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			state.markPhase(&state.dnsStartAt)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			state.endPhase(&state.dnsStartAt, &state.timings.DNS)
+		},
+		ConnectStart: func(string, string) {
+			state.markPhase(&state.connectAt)
+		},
+		ConnectDone: func(string, string, error) {
+			state.endPhase(&state.connectAt, &state.timings.Connect)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			state.mutex.Lock()
+			state.remoteAddr = info.Conn.RemoteAddr().String()
+			state.timings.ConnReused = info.Reused
+			state.timings.ConnIdleTime = info.IdleTime
+			state.mutex.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			state.markPhase(&state.handshakeAt)
+			state.startTimer("TLS handshake", timeouts.TLSHandshake)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			state.endPhase(&state.handshakeAt, &state.timings.TLSHandshake)
+			state.stopTimer()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			state.startTimer("response header", timeouts.ResponseHeader)
+		},
+		GotFirstResponseByte: func() {
+			state.endPhase(&state.getConnAt, &state.timings.FirstByte)
+			state.stopTimer()
+		},
+	}
+	ctx = httptrace.WithClientTrace(context.WithValue(ctx, downloadStateKey{}, state), trace)
+	request = request.WithContext(ctx)
+
+	response, err := d.client.Do(request)
+	result.Redirects = state.redirects
+	result.RemoteAddr = state.remoteAddr
+	result.Timings = &state.timings
 	if err != nil {
-		result.Err = fmt.Errorf("request failed, reason: %v", err)
-		chDoResult <- result
-		return
+		result.Err = d.downloadError(ctx, state, fmt.Errorf("request failed, reason: %v", err))
+		if state.proxy != nil && d.ctx.Err() == nil && !state.connected() {
+			d.proxyPool.ReportFailure(state.proxy)
+		}
+		return result
 	}
+	defer response.Body.Close()
+	defer d.timingStats.add(result.Timings)
 
+	result.URL = response.Request.URL.String()
 	result.StatusCode = response.StatusCode
 	result.Cookies = response.Cookies()
+	if d.cookieJar != nil && task.Session != "" {
+		d.cookieJar.SetCookies(task.Session, response.Request.URL, result.Cookies)
+	}
 	result.Headers = response.Header
-	result.Content, err = ioutil.ReadAll(response.Body)
-	if err != nil {
-		result.Err = fmt.Errorf("read body failed, reason: %v", err)
+
+	if resettable, ok := auth.(ResettableAuthenticator); ok && response.StatusCode == http.StatusUnauthorized {
+		resettable.Reset()
+		result.Err = ErrUnauthorized
+		return result
+	} else if confirmer, ok := auth.(authConfirmer); ok {
+		confirmer.confirm()
+	}
+
+	if cached != nil && response.StatusCode == http.StatusNotModified {
+		d.cache.revalidate(cacheKey, cached, response.Header)
+		cached.fill(result)
+		detectResultCharset(result)
+		return result
+	}
+
+	if !d.allowContentType(response.Header) {
+		result.Err = ErrContentTypeNotAllowed
+		return result
+	}
+	if d.response.MaxBodySize > 0 && response.ContentLength > d.response.MaxBodySize && !d.response.TruncateBody {
+		result.Err = ErrBodyTooLarge
+		return result
+	}
+
+	var decoded io.Reader = response.Body
+	if !d.response.DisableDecompression {
+		decoded, err = decompress(decoded, response.Header)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	bodyStart := time.Now()
+	err = d.readBody(decoded, result)
+	result.Timings.BodyRead = time.Since(bodyStart)
+	if err == ErrBodyTooLarge {
+		result.Err = err
+		return result
+	} else if err != nil {
+		result.Err = d.downloadError(ctx, state, fmt.Errorf("read body failed, reason: %v", err))
+		return result
+	}
+
+	detectResultCharset(result)
+
+	if state.proxy != nil {
+		if d.proxyPool.IsBanned(result) {
+			d.proxyPool.ReportBan(state.proxy)
+			result.Err = ErrProxyBanned
+			return result
+		}
+		d.proxyPool.ReportSuccess(state.proxy)
+	}
+
+	if d.cache != nil {
+		d.cache.store(cacheKey, request, result)
+	}
+
+	return result
+}
+
+func (d *HTTPDownloader) cacheStorage() CacheStorage {
+	if d.cache == nil {
+		return nil
+	}
+	return d.cache.storage
+}
+
+// addCookies adds cookies of the session of the task to the request, and cookies of
+// the task too if taskCookies is true.
+func (d *HTTPDownloader) addCookies(request *http.Request, task *Task, taskCookies bool) {
+	if d.cookieJar != nil && task.Session != "" {
+		for _, cookie := range d.cookieJar.Cookies(task.Session, request.URL) {
+			request.AddCookie(cookie)
+		}
+	}
+	if !taskCookies {
+		return
+	}
+	for _, cookie := range task.Cookies {
+		request.AddCookie(cookie)
 	}
+}
 
-	chDoResult <- result
+// downloadError explains why a request fails if it is aborted through its context.
+func (d *HTTPDownloader) downloadError(ctx context.Context, state *downloadState, err error) error {
+	if timeoutErr := state.timeoutError(); timeoutErr != nil {
+		return timeoutErr
+	}
+
+	if d.ctx.Err() != nil {
+		return ErrDownloaderShuttingDown
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrDownloadTimeout
+	}
+
+	return err
+}
+
+// dialContext dials with the connect timeout of the task being downloaded.
+func (d *HTTPDownloader) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   d.timeouts.Connect,
+		KeepAlive: 30 * time.Second,
+	}
+	if state, ok := ctx.Value(downloadStateKey{}).(*downloadState); ok {
+		dialer.Timeout = state.timeouts.Connect
+	}
+
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil && ctx.Err() == nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, fmt.Errorf("connect timeout after %v", dialer.Timeout)
+		}
+	}
+	return conn, err
 }
 
 // Download read information from task and download content in respect to the task
 func (d *HTTPDownloader) Download(task *Task, chResult chan<- *DownloadResult) {
 	if d.shuttingDown {
-		chResult <- &DownloadResult{Task: task, Err: ErrDownloaderShuttingDown}
+		go func() {
+			chResult <- &DownloadResult{Task: task, Err: ErrDownloaderShuttingDown}
+		}()
 		return
 	}
 
 	d.startTask()
-	ch := make(chan *DownloadResult)
-	go d.doDownload(task, ch)
-
 	go func() {
-		select {
-		case result := <-ch:
-			chResult <- result
-		case <-time.After(d.timeout):
-			chResult <- &DownloadResult{Task: task, Err: ErrDownloadTimeout}
+		result := d.doDownload(task)
+		if d.throttle != nil {
+			d.throttle.feedback(result)
 		}
 		d.finishTask()
+		chResult <- result
 	}()
 }
 
-// Shutdown waits for workers to stop and return
+// ReportStats implements StatsReporter#ReportStats
+func (d *HTTPDownloader) ReportStats(stats *Stats) {
+	stats.Timings = d.timingStats.average()
+}
+
+// Shutdown waits for workers to stop and return. Requests that are still running
+// after the request timeout are aborted.
 func (d *HTTPDownloader) Shutdown() {
 	d.shuttingDown = true
+
+	deadline := time.Now().Add(d.timeouts.Total)
 	for len(d.running) > 0 {
+		if time.Now().After(deadline) {
+			d.cancel()
+		}
 		time.Sleep(1 * time.Second)
 	}
+	d.cancel()
+
+	if d.cookieJar != nil {
+		if err := d.cookieJar.Close(); err != nil {
+			log.Errorf("Fail to close cookie jar, reason: %v", err)
+		}
+	}
+
+	if closer, ok := d.cacheStorage().(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Errorf("Fail to close cache storage, reason: %v", err)
+		}
+	}
 }
@@ -0,0 +1,95 @@
+package krawler
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// allowContentType checks the media type of a response against
+// ResponseConfig.AllowedContentTypes. A pattern such as text/* matches every
+// subtype. A response without Content-Type is always allowed.
+func (d *HTTPDownloader) allowContentType(header http.Header) bool {
+	if len(d.response.AllowedContentTypes) == 0 || header.Get("Content-Type") == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range d.response.AllowedContentTypes {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType || pattern == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, pattern[:len(pattern)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// readBody reads the body of a response into the result. A body larger than
+// StreamThreshold is written into a temporary file, and a body larger than
+// MaxBodySize is truncated or rejected.
+func (d *HTTPDownloader) readBody(body io.Reader, result *DownloadResult) error {
+	limit := d.response.MaxBodySize
+	if limit > 0 {
+		// read one more byte to tell whether the body exceeds the limit
+		body = io.LimitReader(body, limit+1)
+	}
+
+	threshold := d.response.StreamThreshold
+	if threshold <= 0 {
+		content, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		if limit > 0 && int64(len(content)) > limit {
+			if !d.response.TruncateBody {
+				return ErrBodyTooLarge
+			}
+			content = content[:limit]
+			result.Truncated = true
+		}
+		result.Content = content
+		return nil
+	}
+
+	buffer := new(bytes.Buffer)
+	if _, err := io.CopyN(buffer, body, threshold+1); err == io.EOF {
+		result.Content = buffer.Bytes()
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(d.response.StreamDir, "krawler-body-")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	size, err := io.Copy(file, io.MultiReader(buffer, body))
+	if err == nil && limit > 0 && size > limit {
+		if d.response.TruncateBody {
+			err = file.Truncate(limit)
+			result.Truncated = true
+		} else {
+			err = ErrBodyTooLarge
+		}
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	result.BodyPath = file.Name()
+	return nil
+}
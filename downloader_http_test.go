@@ -0,0 +1,175 @@
+package krawler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHTTPDownloaderRedirectLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		if n > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/?n=%d", n-1), http.StatusFound)
+			return
+		}
+		w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		redirects    int
+		maxRedirects int
+		ok           bool
+	}{
+		{0, 1, true},
+		{1, 1, true},
+		{2, 2, true},
+		{2, 1, false},
+		{3, 2, false},
+	}
+
+	for _, test := range tests {
+		config := GetDefaultConfig()
+		config.Request.MaxRedirects = test.maxRedirects
+		downloader := NewHTTPDownloader(config)
+
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: fmt.Sprintf("%s/?n=%d", server.URL, test.redirects), Method: http.MethodGet}, ch)
+		result := <-ch
+		downloader.Shutdown()
+
+		if ok := result.Err == nil; ok != test.ok {
+			t.Errorf("%d redirects with maximum %d: expect ok %v, got error %v", test.redirects, test.maxRedirects, test.ok, result.Err)
+			continue
+		}
+		if test.ok && len(result.Redirects) != test.redirects {
+			t.Errorf("%d redirects with maximum %d: got %d redirects", test.redirects, test.maxRedirects, len(result.Redirects))
+		}
+	}
+}
+
+func TestTimeoutsMerge(t *testing.T) {
+	base := Timeouts{Connect: 1, TLSHandshake: 2, ResponseHeader: 3, Total: 4}
+	tests := []struct {
+		overrides Timeouts
+		expected  Timeouts
+	}{
+		{Timeouts{}, base},
+		{Timeouts{Connect: 10}, Timeouts{Connect: 10, TLSHandshake: 2, ResponseHeader: 3, Total: 4}},
+		{Timeouts{TLSHandshake: 20, Total: 40}, Timeouts{Connect: 1, TLSHandshake: 20, ResponseHeader: 3, Total: 40}},
+		{Timeouts{ResponseHeader: 30, Connect: -1}, Timeouts{Connect: 1, TLSHandshake: 2, ResponseHeader: 30, Total: 4}},
+	}
+
+	for _, test := range tests {
+		if merged := base.merge(test.overrides); merged != test.expected {
+			t.Errorf("%+v: expect %+v, got %+v", test.overrides, test.expected, merged)
+		}
+	}
+}
+
+func TestHTTPDownloaderTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-header" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/slow-body" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	// a listener that never completes a TLS handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		url      string
+		config   Timeouts
+		task     Timeouts
+		expected string
+	}{
+		{server.URL + "/fast", Timeouts{ResponseHeader: 100 * time.Millisecond}, Timeouts{}, ""},
+		{server.URL + "/slow-header", Timeouts{ResponseHeader: 100 * time.Millisecond}, Timeouts{}, "response header timeout after 100ms"},
+		{server.URL + "/slow-header", Timeouts{ResponseHeader: 100 * time.Millisecond}, Timeouts{ResponseHeader: time.Second}, ""},
+		{server.URL + "/slow-header", Timeouts{}, Timeouts{ResponseHeader: 50 * time.Millisecond}, "response header timeout after 50ms"},
+		{server.URL + "/slow-body", Timeouts{ResponseHeader: 100 * time.Millisecond}, Timeouts{}, ""},
+		{server.URL + "/slow-body", Timeouts{Total: 100 * time.Millisecond}, Timeouts{}, ErrDownloadTimeout.Error()},
+		{"https://" + listener.Addr().String() + "/", Timeouts{TLSHandshake: 100 * time.Millisecond}, Timeouts{}, "TLS handshake timeout after 100ms"},
+	}
+
+	for _, test := range tests {
+		config := GetDefaultConfig()
+		config.Request.ResponseHeaderTimeout = test.config.ResponseHeader
+		config.Request.TLSHandshakeTimeout = test.config.TLSHandshake
+		if test.config.Total > 0 {
+			config.Request.Timeout = test.config.Total
+		}
+		downloader := NewHTTPDownloader(config)
+
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: test.url, Method: http.MethodGet, Timeouts: test.task}, ch)
+		result := <-ch
+		downloader.Shutdown()
+
+		message := ""
+		if result.Err != nil {
+			message = result.Err.Error()
+		}
+		if message != test.expected {
+			t.Errorf("%s with %+v and %+v: expect error %q, got %q", test.url, test.config, test.task, test.expected, message)
+		}
+	}
+}
+
+func TestHTTPDownloaderAbortOnShutdown(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	downloader := NewHTTPDownloader(GetDefaultConfig())
+	ch := make(chan *DownloadResult, 1)
+	downloader.Download(&Task{URL: server.URL, Method: http.MethodGet}, ch)
+
+	// Shutdown aborts running requests once the request timeout passes
+	time.Sleep(100 * time.Millisecond)
+	downloader.shuttingDown = true
+	downloader.cancel()
+
+	select {
+	case result := <-ch:
+		if result.Err != ErrDownloaderShuttingDown {
+			t.Errorf("expect %v, got %v", ErrDownloaderShuttingDown, result.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the request to be aborted")
+	}
+
+	downloader.Download(&Task{URL: server.URL, Method: http.MethodGet}, ch)
+	if result := <-ch; result.Err != ErrDownloaderShuttingDown {
+		t.Errorf("expect a task after shutdown to fail with %v, got %v", ErrDownloaderShuttingDown, result.Err)
+	}
+}
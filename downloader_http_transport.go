@@ -0,0 +1,162 @@
+package krawler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTPDownloaderOption customizes a HTTPDownloader.
+type HTTPDownloaderOption func(*HTTPDownloader)
+
+// WithRoundTripper makes the downloader send requests through roundTripper instead
+// of a transport built from TransportConfig. Connect timeouts only work if the
+// round tripper dials with the context of the request.
+func WithRoundTripper(roundTripper http.RoundTripper) HTTPDownloaderOption {
+	return func(d *HTTPDownloader) {
+		d.client.Transport = roundTripper
+	}
+}
+
+// WithProxyPool makes the downloader send every request through a proxy picked
+// from pool. It only works with the transport built from TransportConfig.
+func WithProxyPool(pool *ProxyPool) HTTPDownloaderOption {
+	return func(d *HTTPDownloader) {
+		d.proxyPool = pool
+	}
+}
+
+// WithCookieJar makes the downloader store cookies of tasks with a session in jar
+// and send them with later tasks of the same session.
+func WithCookieJar(jar *CookieJar) HTTPDownloaderOption {
+	return func(d *HTTPDownloader) {
+		d.cookieJar = jar
+	}
+}
+
+// WithCache makes the downloader serve and revalidate responses through cache.
+func WithCache(cache *HTTPCache) HTTPDownloaderOption {
+	return func(d *HTTPDownloader) {
+		d.cache = cache
+	}
+}
+
+// WithAuthenticator makes the downloader authenticate requests to host and its
+// sub-domains with auth.
+func WithAuthenticator(host string, auth Authenticator) HTTPDownloaderOption {
+	return func(d *HTTPDownloader) {
+		d.hostAuths[strings.ToLower(host)] = auth
+	}
+}
+
+// WithProcessorAuthenticator makes the downloader authenticate requests of tasks
+// processed by the processor alias with auth. It takes precedence over the
+// authenticators of hosts.
+func WithProcessorAuthenticator(processorName string, auth Authenticator) HTTPDownloaderOption {
+	return func(d *HTTPDownloader) {
+		d.processorAuths[processorName] = auth
+	}
+}
+
+// WithSigner makes the downloader sign requests to host and its sub-domains with
+// signer, such as a SigV4Signer or a HMACSigner. Signers run right before a
+// request is sent and after every other authenticator, so that signatures cover
+// the final headers and do not expire while tasks wait in the queue. Redirected
+// requests are signed again.
+func WithSigner(host string, signer Authenticator) HTTPDownloaderOption {
+	return func(d *HTTPDownloader) {
+		d.signers[strings.ToLower(host)] = signer
+	}
+}
+
+// proxy returns the proxy of a request, which is the one picked from the proxy
+// pool if any, otherwise the one returned by fallback.
+func (d *HTTPDownloader) proxy(fallback func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(request *http.Request) (*url.URL, error) {
+		if state, ok := request.Context().Value(downloadStateKey{}).(*downloadState); ok && state.proxy != nil {
+			return state.proxy, nil
+		}
+		return fallback(request)
+	}
+}
+
+// newTransport creates a transport according to the config.
+func (d *HTTPDownloader) newTransport(config TransportConfig) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           d.dialContext,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		DisableKeepAlives:     config.DisableKeepAlives,
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
+		// bodies are decoded by the downloader, or kept encoded if
+		// ResponseConfig.DisableDecompression is set
+		DisableCompression: true,
+	}
+
+	if config.DisableHTTP2 {
+		// a non-nil empty map disables HTTP/2 of the transport
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s, reason: %v", config.Proxy, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %s", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport.Proxy = d.proxy(transport.Proxy)
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+
+	if len(config.CACertFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range config.CACertFiles {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("read CA certificates %s failed, reason: %v", file, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no CA certificate is found in %s", file)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate failed, reason: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	if !config.DisableHTTP2 {
+		// a transport with a custom dialer or TLS config only speaks HTTP/2 if it is
+		// configured explicitly
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, fmt.Errorf("enable HTTP/2 failed, reason: %v", err)
+		}
+	}
+	return transport, nil
+}
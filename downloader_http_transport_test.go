@@ -0,0 +1,127 @@
+package krawler
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestNewTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krawler-transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		config TransportConfig
+		fail   string
+	}{
+		{TransportConfig{}, ""},
+		{TransportConfig{Proxy: "http://127.0.0.1:8080"}, ""},
+		{TransportConfig{Proxy: "socks5://127.0.0.1:1080"}, ""},
+		{TransportConfig{Proxy: "ftp://127.0.0.1:21"}, "unsupported proxy scheme ftp"},
+		{TransportConfig{Proxy: "http://[::1"}, "invalid proxy"},
+		{TransportConfig{CACertFiles: []string{filepath.Join(dir, "missing.pem")}}, "read CA certificates"},
+		{TransportConfig{CACertFiles: []string{notPEM}}, "no CA certificate is found"},
+		{TransportConfig{ClientCertFile: notPEM, ClientKeyFile: notPEM}, "load client certificate failed"},
+	}
+
+	for _, test := range tests {
+		_, err := new(HTTPDownloader).newTransport(test.config)
+		if test.fail == "" && err != nil {
+			t.Errorf("%+v: expect no error, got %v", test.config, err)
+		} else if test.fail != "" && (err == nil || !strings.Contains(err.Error(), test.fail)) {
+			t.Errorf("%+v: expect error %q, got %v", test.config, test.fail, err)
+		}
+	}
+
+	transport, err := new(HTTPDownloader).newTransport(TransportConfig{
+		MaxIdleConns:        10,
+		MaxIdleConnsPerHost: 2,
+		MaxConnsPerHost:     4,
+		IdleConnTimeout:     time.Minute,
+		DisableKeepAlives:   true,
+		InsecureSkipVerify:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.MaxIdleConns != 10 || transport.MaxIdleConnsPerHost != 2 || transport.MaxConnsPerHost != 4 ||
+		transport.IdleConnTimeout != time.Minute || !transport.DisableKeepAlives || !transport.TLSClientConfig.InsecureSkipVerify {
+		t.Errorf("expect the transport to follow the config, got %+v", transport)
+	}
+	if !transport.DisableCompression {
+		t.Error("expect compression of the transport to be disabled")
+	}
+}
+
+func TestHTTPDownloaderTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	if err := http2.ConfigureServer(server.Config, nil); err != nil {
+		t.Fatal(err)
+	}
+	server.TLS = server.Config.TLSConfig
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "krawler-transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, certificate, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		config TransportConfig
+		proto  string
+	}{
+		{TransportConfig{}, ""},
+		{TransportConfig{CACertFiles: []string{caFile}}, "HTTP/2.0"},
+		{TransportConfig{InsecureSkipVerify: true}, "HTTP/2.0"},
+		{TransportConfig{CACertFiles: []string{caFile}, DisableHTTP2: true}, "HTTP/1.1"},
+	}
+
+	for _, test := range tests {
+		config := GetDefaultConfig()
+		config.Transport = test.config
+		downloader := NewHTTPDownloader(config)
+
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: server.URL, Method: http.MethodGet}, ch)
+		result := <-ch
+		downloader.Shutdown()
+
+		if test.proto == "" {
+			if result.Err == nil {
+				t.Errorf("%+v: expect an untrusted certificate to fail", test.config)
+			}
+			continue
+		}
+		if result.Err != nil || string(result.Content) != test.proto {
+			t.Errorf("%+v: expect %s, got %q and error %v", test.config, test.proto, result.Content, result.Err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package krawler
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MapResponse is a canned response of MapDownloader.
+type MapResponse struct {
+	StatusCode int
+	Headers    http.Header
+	Content    []byte
+	Err        error
+}
+
+// MapDownloader returns canned responses from memory, keyed by the method and
+// the URL of tasks. It is meant for testing processors.
+type MapDownloader struct {
+	mutex     sync.RWMutex
+	responses map[string]*MapResponse
+}
+
+// NewMapDownloader creates an empty map downloader.
+func NewMapDownloader() *MapDownloader {
+	return &MapDownloader{responses: make(map[string]*MapResponse)}
+}
+
+func mapKey(method, url string) string {
+	if method == "" {
+		method = http.MethodGet
+	}
+	return method + " " + url
+}
+
+// Set sets the response of requests with method to url.
+func (d *MapDownloader) Set(method string, url string, response *MapResponse) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.responses[mapKey(method, url)] = response
+}
+
+// SetContent sets a 200 response of GET requests to url.
+func (d *MapDownloader) SetContent(url string, contentType string, content string) {
+	d.Set(http.MethodGet, url, &MapResponse{
+		StatusCode: http.StatusOK,
+		Headers:    http.Header{"Content-Type": {contentType}},
+		Content:    []byte(content),
+	})
+}
+
+// Download implements Downloader#Download. A task without a canned response gets a
+// 404 response.
+func (d *MapDownloader) Download(task *Task, chResult chan<- *DownloadResult) {
+	task.Meta.DownloadStartTime = time.Now()
+
+	d.mutex.RLock()
+	response, exists := d.responses[mapKey(task.Method, task.URL)]
+	d.mutex.RUnlock()
+
+	result := &DownloadResult{Task: task, URL: task.URL, StatusCode: http.StatusNotFound, Headers: make(http.Header)}
+	if exists {
+		result.StatusCode = response.StatusCode
+		result.Content = response.Content
+		result.Err = response.Err
+		for field, values := range response.Headers {
+			result.Headers[field] = values
+		}
+	}
+	detectResultCharset(result)
+
+	task.Meta.DownloadFinishTime = time.Now()
+	deliver(result, chResult)
+}
+
+// Shutdown implements Downloader#Shutdown
+func (d *MapDownloader) Shutdown() {
+}
@@ -0,0 +1,297 @@
+package krawler
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+
+	json "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNotRecorded indicates a strict ReplayDownloader receives a task that is not
+// recorded in the cassette
+var ErrNotRecorded = errors.New("task is not recorded in the cassette")
+
+// replayedErrors are errors that keep their identity when they are replayed, so
+// that the engine handles them in the same way as when they are recorded.
+var replayedErrors = []error{
+	ErrDownloadTimeout, ErrBodyTooLarge, ErrContentTypeNotAllowed, ErrNoProxyAvailable,
+	ErrProxyBanned, ErrNotRecorded, ErrUnauthorized,
+}
+
+// credentialHeaders are request and response header fields that are not written
+// into cassettes.
+var credentialHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token", "X-Csrf-Token",
+}
+
+// Cassette defines the structure of a file recorded by RecordingDownloader.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded download of a task.
+type Interaction struct {
+	// Hash is the HashCode of the task.
+	Hash     string              `json:"hash"`
+	Request  InteractionRequest  `json:"request"`
+	Response InteractionResponse `json:"response"`
+}
+
+// InteractionRequest records the task of an interaction.
+type InteractionRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// InteractionResponse records the download result of an interaction. Cookies set
+// by the response are not recorded.
+type InteractionResponse struct {
+	URL        string      `json:"url,omitempty"`
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Redirects  []Redirect  `json:"redirects,omitempty"`
+	Charset    string      `json:"charset,omitempty"`
+	Content    []byte      `json:"content,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// LoadCassette reads a cassette file.
+func LoadCassette(path string) (*Cassette, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read cassette %s failed, reason: %v", path, err)
+	}
+
+	cassette := new(Cassette)
+	if err := json.Unmarshal(raw, cassette); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s, reason: %v", path, err)
+	}
+	return cassette, nil
+}
+
+// Save writes the cassette into a file.
+func (c *Cassette) Save(path string) error {
+	raw, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal cassette failed, reason: %v", err)
+	}
+
+	// write to a temporary file first so that a crash never leaves a broken file
+	if err := ioutil.WriteFile(path+".tmp", raw, 0644); err != nil {
+		return fmt.Errorf("write cassette %s failed, reason: %v", path, err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// RecordingDownloader wraps a downloader and records every download into a
+// cassette file, which is written when the downloader shuts down.
+type RecordingDownloader struct {
+	downloader Downloader
+	path       string
+
+	mutex    sync.Mutex
+	cassette *Cassette
+}
+
+// NewRecordingDownloader creates a downloader that records downloads of downloader
+// into the cassette at path.
+func NewRecordingDownloader(downloader Downloader, path string) *RecordingDownloader {
+	return &RecordingDownloader{
+		downloader: downloader,
+		path:       path,
+		cassette:   new(Cassette),
+	}
+}
+
+// Download implements Downloader#Download
+func (d *RecordingDownloader) Download(task *Task, chResult chan<- *DownloadResult) {
+	ch := make(chan *DownloadResult)
+	d.downloader.Download(task, ch)
+
+	go func() {
+		result := <-ch
+		if _, postponed := result.Err.(*PostponeError); !postponed && result.Err != ErrDownloaderShuttingDown {
+			d.record(task, result)
+		}
+		chResult <- result
+	}()
+}
+
+func (d *RecordingDownloader) record(task *Task, result *DownloadResult) {
+	interaction := &Interaction{
+		Hash: task.HashCode(),
+		Request: InteractionRequest{
+			Method:  task.Method,
+			URL:     task.URL,
+			Headers: withoutCredentials(task.Headers),
+			Body:    task.Body,
+		},
+		Response: InteractionResponse{
+			URL:        result.URL,
+			StatusCode: result.StatusCode,
+			Headers:    withoutCredentials(result.Headers),
+			Redirects:  result.Redirects,
+			Charset:    result.Charset,
+		},
+	}
+
+	if result.Err != nil {
+		interaction.Response.Error = result.Err.Error()
+	} else {
+		content, err := result.Bytes()
+		if err != nil {
+			log.Errorf("Fail to record the body of task %s, reason: %v", task.Name(), err)
+		}
+		interaction.Response.Content = content
+	}
+
+	d.mutex.Lock()
+	d.cassette.Interactions = append(d.cassette.Interactions, interaction)
+	d.mutex.Unlock()
+}
+
+// withoutCredentials returns a copy of header without credentialHeaders.
+func withoutCredentials(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+
+	copied := make(http.Header, len(header))
+	for field, values := range header {
+		copied[field] = values
+	}
+	for _, field := range credentialHeaders {
+		copied.Del(field)
+	}
+	return copied
+}
+
+// ReportStats implements StatsReporter#ReportStats if the wrapped downloader
+// reports stats.
+func (d *RecordingDownloader) ReportStats(stats *Stats) {
+	if reporter, ok := d.downloader.(StatsReporter); ok {
+		reporter.ReportStats(stats)
+	}
+}
+
+// Shutdown shuts down the wrapped downloader and writes the cassette
+func (d *RecordingDownloader) Shutdown() {
+	d.downloader.Shutdown()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.cassette.Save(d.path); err != nil {
+		log.Errorf("Fail to save cassette, reason: %v", err)
+	} else {
+		log.Infof("Recorded %d interactions into %s", len(d.cassette.Interactions), d.path)
+	}
+}
+
+// ReplayDownloader serves downloads recorded in a cassette, looked up by the hash
+// code of tasks. If a task is recorded several times, the recordings are served in
+// order and the last one is repeated.
+type ReplayDownloader struct {
+	fallback Downloader
+
+	mutex        sync.Mutex
+	interactions map[string][]*Interaction
+	misses       []string
+}
+
+// NewReplayDownloader creates a downloader replaying the cassette at path. Tasks
+// that are not recorded are handed to fallback. If fallback is nil, the downloader
+// is strict and fails such tasks with ErrNotRecorded.
+func NewReplayDownloader(path string, fallback Downloader) (*ReplayDownloader, error) {
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &ReplayDownloader{
+		fallback:     fallback,
+		interactions: make(map[string][]*Interaction),
+	}
+	for _, interaction := range cassette.Interactions {
+		d.interactions[interaction.Hash] = append(d.interactions[interaction.Hash], interaction)
+	}
+	return d, nil
+}
+
+// next returns the interaction to replay for a task.
+func (d *ReplayDownloader) next(task *Task) *Interaction {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	hash := task.HashCode()
+	interactions := d.interactions[hash]
+	if len(interactions) == 0 {
+		d.misses = append(d.misses, hash)
+		return nil
+	}
+
+	if len(interactions) > 1 {
+		d.interactions[hash] = interactions[1:]
+	}
+	return interactions[0]
+}
+
+// Download implements Downloader#Download
+func (d *ReplayDownloader) Download(task *Task, chResult chan<- *DownloadResult) {
+	interaction := d.next(task)
+	if interaction == nil && d.fallback != nil {
+		d.fallback.Download(task, chResult)
+		return
+	}
+
+	result := &DownloadResult{Task: task}
+	if interaction == nil {
+		log.Errorf("Task %s is not recorded in the cassette", task.Name())
+		result.Err = ErrNotRecorded
+	} else {
+		response := interaction.Response
+		result.URL = response.URL
+		result.StatusCode = response.StatusCode
+		result.Headers = response.Headers
+		result.Redirects = response.Redirects
+		result.Charset = response.Charset
+		result.Content = response.Content
+		if response.Error != "" {
+			result.Err = replayedError(response.Error)
+		}
+	}
+
+	deliver(result, chResult)
+}
+
+// replayedError turns a recorded error message back into an error.
+func replayedError(message string) error {
+	for _, err := range replayedErrors {
+		if err.Error() == message {
+			return err
+		}
+	}
+	return errors.New(message)
+}
+
+// Misses returns the hash codes of tasks that are not recorded in the cassette.
+func (d *ReplayDownloader) Misses() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return append([]string(nil), d.misses...)
+}
+
+// Shutdown shuts down the fallback downloader
+func (d *ReplayDownloader) Shutdown() {
+	if d.fallback != nil {
+		d.fallback.Shutdown()
+	}
+}
@@ -0,0 +1,88 @@
+package krawler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "cookie-secret"})
+		w.Header().Set("X-Auth-Token", "token-secret")
+		w.Header().Set("X-Page", r.URL.Path)
+		w.Write([]byte("page " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "krawler-cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+
+	newTask := func(page string) *Task {
+		return &Task{
+			URL:     server.URL + page,
+			Method:  http.MethodGet,
+			Headers: http.Header{"Authorization": {"Bearer auth-secret"}, "Cookie": {"id=cookie-secret"}},
+		}
+	}
+
+	recorder := NewRecordingDownloader(NewHTTPDownloader(GetDefaultConfig()), path)
+	ch := make(chan *DownloadResult, 1)
+	recorder.Download(newTask("/a"), ch)
+	if result := <-ch; result.Err != nil || len(result.Cookies) != 1 {
+		t.Fatalf("expect the recorded download to succeed with a cookie, got %v and %v", result.Err, result.Cookies)
+	}
+	recorder.Shutdown()
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"auth-secret", "cookie-secret", "token-secret"} {
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("expect %s not to be recorded, got %s", secret, raw)
+		}
+	}
+
+	replayer, err := NewReplayDownloader(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Shutdown()
+
+	tests := []struct {
+		page    string
+		content string
+		err     error
+	}{
+		{"/a", "page /a", nil},
+		{"/b", "", ErrNotRecorded},
+	}
+
+	for _, test := range tests {
+		ch := make(chan *DownloadResult, 1)
+		replayer.Download(newTask(test.page), ch)
+		result := <-ch
+		if result.Err != test.err {
+			t.Errorf("%s: expect error %v, got %v", test.page, test.err, result.Err)
+			continue
+		}
+		if string(result.Content) != test.content {
+			t.Errorf("%s: expect %q, got %q", test.page, test.content, result.Content)
+		}
+		if test.err == nil && result.Headers.Get("X-Page") != test.page {
+			t.Errorf("%s: expect headers to be replayed, got %v", test.page, result.Headers)
+		}
+	}
+	if misses := replayer.Misses(); len(misses) != 1 {
+		t.Errorf("expect 1 miss, got %v", misses)
+	}
+}
@@ -0,0 +1,90 @@
+package krawler
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// RouterDownloader hands every task to a downloader chosen by the scheme of its
+// URL, so that a crawl can mix http, file and data URLs with a single downloader
+// installed on the engine.
+type RouterDownloader struct {
+	routes map[string]Downloader
+}
+
+// NewRouterDownloader creates a router downloader. routes maps URL schemes to
+// downloaders.
+func NewRouterDownloader(routes map[string]Downloader) *RouterDownloader {
+	d := &RouterDownloader{routes: make(map[string]Downloader)}
+	for scheme, downloader := range routes {
+		d.routes[strings.ToLower(scheme)] = downloader
+	}
+	return d
+}
+
+// NewDefaultRouterDownloader creates a router downloader that downloads http and
+// https URLs with a HTTPDownloader, file URLs with a FileDownloader without root
+// and data URLs with a DataURLDownloader. Files are limited by the MaxBodySize of
+// the config.
+func NewDefaultRouterDownloader(config *Config, options ...HTTPDownloaderOption) *RouterDownloader {
+	httpDownloader := NewHTTPDownloader(config, options...)
+	fileDownloader, _ := NewFileDownloader("", WithFileSizeLimit(config.Response.MaxBodySize, config.Response.TruncateBody))
+
+	return NewRouterDownloader(map[string]Downloader{
+		"http":  httpDownloader,
+		"https": httpDownloader,
+		"file":  fileDownloader,
+		"data":  NewDataURLDownloader(),
+	})
+}
+
+// Download implements Downloader#Download. A task whose URL is invalid or has a
+// scheme without a route fails with a PermanentError.
+func (d *RouterDownloader) Download(task *Task, chResult chan<- *DownloadResult) {
+	u, err := url.Parse(task.URL)
+	if err != nil {
+		deliver(&DownloadResult{Task: task, Err: &PermanentError{Reason: fmt.Sprintf("invalid url %s, reason: %v", task.URL, err)}}, chResult)
+		return
+	}
+
+	downloader, exists := d.routes[strings.ToLower(u.Scheme)]
+	if !exists {
+		deliver(&DownloadResult{Task: task, Err: &PermanentError{Reason: fmt.Sprintf("no downloader is routed for scheme %s", u.Scheme)}}, chResult)
+		return
+	}
+
+	downloader.Download(task, chResult)
+}
+
+// Shutdown shuts down every routed downloader once
+func (d *RouterDownloader) Shutdown() {
+	wg := sync.WaitGroup{}
+	done := make(map[Downloader]bool)
+	for _, downloader := range d.routes {
+		if done[downloader] {
+			continue
+		}
+		done[downloader] = true
+
+		wg.Add(1)
+		go func(downloader Downloader) {
+			downloader.Shutdown()
+			wg.Done()
+		}(downloader)
+	}
+	wg.Wait()
+}
+
+// ReportStats implements StatsReporter#ReportStats by asking every routed
+// downloader that reports stats.
+func (d *RouterDownloader) ReportStats(stats *Stats) {
+	done := make(map[Downloader]bool)
+	for _, downloader := range d.routes {
+		if reporter, ok := downloader.(StatsReporter); ok && !done[downloader] {
+			done[downloader] = true
+			reporter.ReportStats(stats)
+		}
+	}
+}
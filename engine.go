@@ -1,6 +1,8 @@
 package krawler
 
 import (
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"reflect"
@@ -16,10 +18,16 @@ type Engine struct {
 	Config *Config
 
 	downloader       Downloader
+	middlewares      []DownloaderMiddleware
 	queue            Queue
 	processors       map[string]FuncProcessor
+	processorOptions map[string]ProcessorOptions
 	shuttingDown     bool
 	downloadingCount *int64
+	counters         *engineCounters
+
+	postponeMutex sync.Mutex
+	postponed     map[*Task]*time.Timer
 }
 
 var defaultEngine *Engine
@@ -38,9 +46,16 @@ func (e *Engine) Initialize(config *Config) {
 	log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
 
 	e.processors = make(map[string]FuncProcessor)
+	e.processorOptions = make(map[string]ProcessorOptions)
 	e.downloadingCount = new(int64)
+	e.counters = new(engineCounters)
+	e.postponed = make(map[*Task]*time.Timer)
 	e.Config = config
 	config.checkConfig()
+
+	if config.Breaker.Enabled {
+		e.InstallMiddleware(NewCircuitBreaker(config.Breaker))
+	}
 }
 
 // InstallQueue installs a task queue onto the engine
@@ -50,16 +65,51 @@ func (e *Engine) InstallQueue(queue Queue) {
 	}
 
 	e.queue = queue
+	if _, ok := queue.(visitMarker); !ok && e.Config != nil && e.Config.Request.DeduplicateFinalURL {
+		log.Warnf("%s cannot mark final urls as visited, DeduplicateFinalURL is ignored", reflect.TypeOf(queue))
+	}
+	if q, ok := queue.(dedupKeyQueue); ok {
+		q.setDedupKeyFunc(e.dedupKey)
+	}
+}
+
+// ProcessorOptions defines how tasks of a processor are handled by the engine.
+type ProcessorOptions struct {
+	// RecrawlInterval is how long a task of the processor stays a duplicate after
+	// it is enqueued, unless the task has its own RecrawlInterval. Zero means
+	// forever.
+	RecrawlInterval time.Duration
+
+	// DedupKey returns the key of a task of the processor in the duplication check,
+	// replacing Task.HashCode. Keys are compared across processors, so processors
+	// returning the same key for different tasks treat them as duplicates. It is
+	// applied by the queues of this package.
+	DedupKey func(task *Task) string
+}
+
+// dedupKey returns the key of a task computed by the DedupKey function of its
+// processor, or an empty string if there is none.
+func (e *Engine) dedupKey(task *Task) string {
+	if dedupKey := e.processorOptions[task.ProcessorName].DedupKey; dedupKey != nil {
+		return dedupKey(task)
+	}
+	return ""
 }
 
 // InstallProcessor registers processor into engine
 func (e *Engine) InstallProcessor(processor FuncProcessor, aliases ...string) {
+	e.InstallProcessorWithOptions(processor, ProcessorOptions{}, aliases...)
+}
+
+// InstallProcessorWithOptions registers processor into engine with options
+func (e *Engine) InstallProcessorWithOptions(processor FuncProcessor, options ProcessorOptions, aliases ...string) {
 	for _, alias := range aliases {
 		log.Debugf("Added processor with alias `%s`", alias)
 		if _, exists := e.processors[alias]; exists {
 			log.Fatalf("A processor with alias `%s` has already been added.", alias)
 		}
 		e.processors[alias] = processor
+		e.processorOptions[alias] = options
 	}
 }
 
@@ -72,6 +122,14 @@ func (e *Engine) InstallDownloader(downloader Downloader) {
 	e.downloader = downloader
 }
 
+// InstallMiddleware appends downloader middlewares onto the engine. BeforeDownload
+// hooks are called in installation order, while AfterDownload and OnDownloadError
+// hooks are called in reverse order. If a BeforeDownload hook fails, only the
+// middlewares before it have their OnDownloadError hooks called.
+func (e *Engine) InstallMiddleware(middlewares ...DownloaderMiddleware) {
+	e.middlewares = append(e.middlewares, middlewares...)
+}
+
 // AddTask adds task to the queue
 func (e *Engine) AddTask(tasks ...*Task) {
 	for _, task := range tasks {
@@ -82,6 +140,9 @@ func (e *Engine) AddTask(tasks ...*Task) {
 			log.Warnf("Ignore task with processor missing. ProcessName=%s", task.ProcessorName)
 			continue
 		}
+		if taskCopy.RecrawlInterval == 0 {
+			taskCopy.RecrawlInterval = e.processorOptions[task.ProcessorName].RecrawlInterval
+		}
 
 		err := e.queue.Enqueue(&taskCopy, task.AllowDuplication, EnqueuePositionTail)
 		if err == ErrQueueTaskDuplicated {
@@ -94,12 +155,36 @@ func (e *Engine) AddTask(tasks ...*Task) {
 	}
 }
 
+// ForgetTask removes tasks from the duplication check of the queue, so that they
+// can be added again before their recrawl interval expires. It fails with
+// ErrQueueForgetNotSupported if the queue cannot forget tasks.
+func (e *Engine) ForgetTask(tasks ...*Task) error {
+	forgetter, ok := e.queue.(taskForgetter)
+	if !ok {
+		return ErrQueueForgetNotSupported
+	}
+
+	for _, task := range tasks {
+		if err := forgetter.Forget(task); err != nil {
+			return fmt.Errorf("fail to forget task %s, reason: %v", task.Name(), err)
+		}
+	}
+	return nil
+}
+
+// ForgetURL removes the GET task of rawURL processed by processorName from the
+// duplication check of the queue.
+func (e *Engine) ForgetURL(rawURL string, processorName string) error {
+	return e.ForgetTask(&Task{URL: rawURL, Method: http.MethodGet, ProcessorName: processorName})
+}
+
 // RetryTask will check if a task exceeds maximum retry times and reschedule it with highest priority
 func (e *Engine) RetryTask(task *Task) {
 	taskName := task.Name()
 
 	if task.Meta.RetryTimes >= e.Config.Request.MaxRetryTimes {
 		log.Errorf("Task %s is removed because it has exceeds maximum retry times", taskName)
+		atomic.AddInt64(&e.counters.dropped, 1)
 		return
 	}
 
@@ -110,6 +195,7 @@ func (e *Engine) RetryTask(task *Task) {
 		log.Errorf("Fail to reschedule a task %s for retrying, reason: %v", taskName, err)
 	} else {
 		task.Meta.EnqueueTime = time.Now()
+		atomic.AddInt64(&e.counters.retried, 1)
 		log.Debugf("Task %s has been reschedule for retrying", taskName)
 	}
 }
@@ -124,33 +210,153 @@ func (e *Engine) RescheduleTask(task *Task) {
 	}
 }
 
+// PostponeTask puts a task back into the queue after delay without counting a
+// retry. Postponed tasks count as running so that the engine keeps waiting for
+// them, and they are put back immediately when the engine shuts down.
+func (e *Engine) PostponeTask(task *Task, delay time.Duration) {
+	e.postponeMutex.Lock()
+	defer e.postponeMutex.Unlock()
+
+	if e.shuttingDown {
+		e.RescheduleTask(task)
+		return
+	}
+
+	atomic.AddInt64(e.downloadingCount, 1)
+	atomic.AddInt64(&e.counters.postponed, 1)
+	e.postponed[task] = time.AfterFunc(delay, func() {
+		e.postponeMutex.Lock()
+		defer e.postponeMutex.Unlock()
+
+		if _, exists := e.postponed[task]; !exists {
+			return
+		}
+		delete(e.postponed, task)
+		e.RescheduleTask(task)
+		atomic.AddInt64(e.downloadingCount, -1)
+	})
+	log.Debugf("Task %s has been postponed for %v", task.Name(), delay)
+}
+
+// flushPostponedTasks puts every postponed task back into the queue immediately.
+func (e *Engine) flushPostponedTasks() {
+	e.postponeMutex.Lock()
+	defer e.postponeMutex.Unlock()
+
+	for task, timer := range e.postponed {
+		timer.Stop()
+		delete(e.postponed, task)
+		e.RescheduleTask(task)
+		atomic.AddInt64(e.downloadingCount, -1)
+	}
+}
+
 func (e *Engine) handleDownloadTask(chResult chan *DownloadResult) {
 	defer func() {
 		atomic.AddInt64(e.downloadingCount, -1)
 	}()
 
 	result := <-chResult
+	defer result.Release()
 	task := result.Task
 	taskName := task.Name()
 
+	if result.Err != ErrDownloaderShuttingDown {
+		e.afterDownload(result)
+	}
+
+	if postpone, ok := result.Err.(*PostponeError); ok {
+		if postpone.Failure {
+			atomic.AddInt64(&e.counters.downloadFailed, 1)
+			if task.Meta.RetryTimes >= e.Config.Request.MaxRetryTimes {
+				log.Errorf("Task %s is removed because it has exceeds maximum retry times, last %v", taskName, postpone)
+				atomic.AddInt64(&e.counters.dropped, 1)
+				return
+			}
+			task.Meta.RetryTimes++
+			atomic.AddInt64(&e.counters.retried, 1)
+		}
+		log.Infof("Task %s is %v", taskName, postpone)
+		e.PostponeTask(task, postpone.Delay)
+		return
+	}
+
 	if result.Err == ErrDownloaderShuttingDown {
 		e.RescheduleTask(task)
 		return
+	} else if isPermanentDownloadError(result.Err) {
+		log.Warnf("Task %s is removed because %v", taskName, result.Err)
+		atomic.AddInt64(&e.counters.downloadFailed, 1)
+		atomic.AddInt64(&e.counters.dropped, 1)
+		return
 	} else if result.Err != nil {
 		log.Errorf("Download task %s failed, reason: %v", taskName, result.Err)
+		atomic.AddInt64(&e.counters.downloadFailed, 1)
 		e.RetryTask(task)
 		return
 	}
+	atomic.AddInt64(&e.counters.downloaded, 1)
+
+	if e.duplicatedFinalURL(result) {
+		log.Infof("Ignore task %s because final url %s is duplicated", taskName, result.URL)
+		return
+	}
 
 	processor := e.processors[task.ProcessorName]
 	err := processor(result, e)
 	if err != nil {
 		log.Errorf("Process task %s failed, reason: %v", taskName, err)
+		atomic.AddInt64(&e.counters.processFailed, 1)
 		if !task.DontRetryIfProcessorFails {
 			e.RetryTask(task)
 		}
 		return
 	}
+	atomic.AddInt64(&e.counters.processed, 1)
+}
+
+// duplicatedFinalURL marks the final URL of a redirected task as visited if
+// Config.Request.DeduplicateFinalURL is on, and reports whether it has been
+// visited before.
+func (e *Engine) duplicatedFinalURL(result *DownloadResult) bool {
+	task := result.Task
+	// a retried task has marked its final URL at the first attempt
+	if !e.Config.Request.DeduplicateFinalURL || len(result.Redirects) == 0 || task.AllowDuplication || task.Meta.RetryTimes > 0 {
+		return false
+	}
+	marker, ok := e.queue.(visitMarker)
+	if !ok {
+		return false
+	}
+
+	finalTask := *task
+	finalTask.URL = result.URL
+	// the key of the task itself is marked when it is enqueued
+	if marker.dedupKey(&finalTask) == marker.dedupKey(task) {
+		return false
+	}
+
+	visited, err := marker.MarkVisited(&finalTask)
+	if err != nil {
+		log.Errorf("Fail to check duplication of final url %s, reason: %v", result.URL, err)
+		return false
+	}
+	return visited
+}
+
+// isPermanentDownloadError tells whether a download error would happen again if
+// the task was retried.
+func isPermanentDownloadError(err error) bool {
+	switch err.(type) {
+	case *PermanentError:
+		return true
+	}
+
+	switch err {
+	case ErrBodyTooLarge, ErrContentTypeNotAllowed, ErrNotRecorded:
+		return true
+	}
+	return false
 }
 
 func (e *Engine) runTask(task *Task) {
@@ -163,10 +369,52 @@ func (e *Engine) runTask(task *Task) {
 	}()
 
 	log.Debugf("Run task %s", task.Name())
-	ch := make(chan *DownloadResult)
+	// the buffer keeps a downloader from blocking if a panic has already been
+	// reported as the result
+	ch := make(chan *DownloadResult, 1)
 	atomic.AddInt64(e.downloadingCount, 1)
-	e.downloader.Download(task, ch)
 	go e.handleDownloadTask(ch)
+	e.download(task, ch)
+}
+
+// download passes the task through BeforeDownload hooks of middlewares and hands
+// it to the downloader. A panic of a middleware or the downloader fails the
+// download, so that the result handler does not wait forever.
+func (e *Engine) download(task *Task, ch chan *DownloadResult) {
+	skipped := len(e.middlewares)
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("Recover from panic while downloading task %s, panic: %s", task.Name(), err)
+			select {
+			case ch <- &DownloadResult{Task: task, Err: fmt.Errorf("panic while downloading, reason: %v", err), skippedMiddlewares: skipped}:
+			default:
+			}
+		}
+	}()
+
+	for _, middleware := range e.middlewares {
+		if err := middleware.BeforeDownload(task); err != nil {
+			ch <- &DownloadResult{Task: task, Err: err, skippedMiddlewares: skipped}
+			return
+		}
+		skipped--
+	}
+
+	e.downloader.Download(task, ch)
+}
+
+// afterDownload passes the result through AfterDownload and OnDownloadError hooks
+// of middlewares in reverse order. A middleware whose BeforeDownload has not
+// succeeded is skipped.
+func (e *Engine) afterDownload(result *DownloadResult) {
+	for i := len(e.middlewares) - 1 - result.skippedMiddlewares; i >= 0; i-- {
+		middleware := e.middlewares[i]
+		if result.Err != nil {
+			middleware.OnDownloadError(result)
+		} else if err := middleware.AfterDownload(result); err != nil {
+			result.Err = err
+		}
+	}
 }
 
 func (e *Engine) work(complete chan<- bool) {
@@ -193,7 +441,7 @@ func (e *Engine) work(complete chan<- bool) {
 			}
 		}
 
-		e.runTask(task.(*Task))
+		e.runTask(task)
 	}
 
 	log.Infof("No new tasks to be run. Crawler stops")
@@ -219,7 +467,7 @@ func (e *Engine) Start() {
 	chComplete := make(chan bool)
 	go e.work(chComplete)
 
-	chSigInt := make(chan os.Signal)
+	chSigInt := make(chan os.Signal, 1)
 	signal.Notify(chSigInt, os.Interrupt)
 
 	select {
@@ -230,10 +478,25 @@ func (e *Engine) Start() {
 
 	signal.Reset(os.Interrupt)
 	e.shutdownElegantly()
+
+	stats := e.Stats()
+	log.Infof("Crawler stats: downloaded=%d download_failed=%d processed=%d process_failed=%d retried=%d postponed=%d dropped=%d",
+		stats.Downloaded, stats.DownloadFailed, stats.Processed, stats.ProcessFailed, stats.Retried, stats.Postponed, stats.Dropped)
+	if timings := stats.Timings; timings.Requests > 0 {
+		log.Infof("Request timings: requests=%d reused_conns=%d dns=%v connect=%v tls=%v first_byte=%v body_read=%v",
+			timings.Requests, timings.ReusedConns, timings.DNS, timings.Connect, timings.TLSHandshake, timings.FirstByte, timings.BodyRead)
+	}
+	for host, state := range stats.Breakers {
+		log.Infof("Circuit of host %s is %s", host, state)
+	}
 }
 
 func (e *Engine) shutdownElegantly() {
+	e.postponeMutex.Lock()
 	e.shuttingDown = true
+	e.postponeMutex.Unlock()
+	e.flushPostponedTasks()
+
 	wg := sync.WaitGroup{}
 
 	wg.Add(2)
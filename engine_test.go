@@ -0,0 +1,235 @@
+package krawler
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEngineDedupKey(t *testing.T) {
+	e := new(Engine)
+	e.Initialize(&Config{})
+	e.InstallQueue(NewLocalQueue())
+	noop := func(*DownloadResult, *Engine) error { return nil }
+	e.InstallProcessorWithOptions(noop, ProcessorOptions{DedupKey: DedupByQueryParams("id")}, "item")
+	e.InstallProcessor(noop, "page")
+
+	steps := []struct {
+		task   *Task
+		length int64
+	}{
+		{&Task{Method: "GET", URL: "http://example.com/item?id=1&sid=a", ProcessorName: "item"}, 1},
+		{&Task{Method: "GET", URL: "http://example.com/item?id=1&sid=b", ProcessorName: "item"}, 1},
+		{&Task{Method: "GET", URL: "http://example.com/item?id=2&sid=b", ProcessorName: "item"}, 2},
+		{&Task{Method: "GET", URL: "http://example.com/page?id=1&sid=a", ProcessorName: "page"}, 3},
+		{&Task{Method: "GET", URL: "http://example.com/page?id=1&sid=b", ProcessorName: "page"}, 4},
+	}
+	for i, step := range steps {
+		e.AddTask(step.task)
+		if length, _ := e.queue.Len(); length != step.length {
+			t.Fatalf("step %d: expect %d tasks, got %d", i, step.length, length)
+		}
+	}
+
+	// a popped task is copied and changed by processors, so its key must not be
+	// the one computed when it was added
+	popped, err := e.queue.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := *popped
+	changed.URL = "http://example.com/item?id=3"
+	e.AddTask(&changed)
+	if length, _ := e.queue.Len(); length != 4 {
+		t.Errorf("expect the changed task to be added, got %d tasks", length)
+	}
+
+	if err := e.ForgetTask(&Task{Method: "GET", URL: "http://example.com/item?id=1&sid=c", ProcessorName: "item"}); err != nil {
+		t.Fatal(err)
+	}
+	e.AddTask(popped)
+	if length, _ := e.queue.Len(); length != 5 {
+		t.Errorf("expect the forgotten task to be added again, got %d tasks", length)
+	}
+}
+
+func TestEngineDeduplicateFinalURL(t *testing.T) {
+	config := &Config{}
+	config.Request.DeduplicateFinalURL = true
+	e := new(Engine)
+	e.Initialize(config)
+	e.InstallQueue(NewLocalQueue(WithURLNormalizer(NewURLNormalizer())))
+
+	var processed []string
+	e.InstallProcessor(func(result *DownloadResult, _ *Engine) error {
+		processed = append(processed, result.Task.URL)
+		return nil
+	}, "page")
+
+	redirects := []Redirect{{}}
+	steps := []struct {
+		url       string
+		finalURL  string
+		redirects []Redirect
+		processed bool
+	}{
+		// the final URL has the same key as the task after normalization
+		{"http://example.com/a?utm_source=x", "http://example.com/a", redirects, true},
+		{"http://example.com/b", "http://example.com/c", redirects, true},
+		{"http://example.com/d", "http://example.com/c", redirects, false},
+		{"http://example.com/f", "http://example.com/f", nil, true},
+		{"http://example.com/e", "http://example.com/a", redirects, false},
+	}
+
+	for i, step := range steps {
+		task := &Task{URL: step.url, Method: "GET", ProcessorName: "page"}
+		if err := e.queue.Enqueue(task, false, EnqueuePositionTail); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		e.queue.Pop()
+
+		processed = nil
+		ch := make(chan *DownloadResult, 1)
+		ch <- &DownloadResult{Task: task, URL: step.finalURL, Redirects: step.redirects}
+		atomic.AddInt64(e.downloadingCount, 1)
+		e.handleDownloadTask(ch)
+
+		if (len(processed) == 1) != step.processed {
+			t.Errorf("step %d: expect %s processed %v, got %v", i, step.url, step.processed, processed)
+		}
+	}
+}
+
+// panicDownloader answers every task successfully unless it is told to panic.
+type panicDownloader struct {
+	panics bool
+}
+
+func (d *panicDownloader) Download(task *Task, ch chan<- *DownloadResult) {
+	if d.panics {
+		panic("downloader")
+	}
+	ch <- &DownloadResult{Task: task, StatusCode: 200}
+}
+
+func (d *panicDownloader) Shutdown() {}
+
+func TestEngineMiddlewares(t *testing.T) {
+	steps := []struct {
+		failAt     string
+		panicAt    string
+		downloader bool
+		calls      string
+		processed  bool
+	}{
+		{calls: "before 1,before 2,after 2,after 1", processed: true},
+		{failAt: "before 2", calls: "before 1,before 2,error 1"},
+		{panicAt: "before 2", calls: "before 1,before 2,error 1"},
+		{panicAt: "before 1", calls: "before 1"},
+		{downloader: true, calls: "before 1,before 2,error 2,error 1"},
+		{failAt: "after 2", calls: "before 1,before 2,after 2,error 1"},
+	}
+
+	for i, step := range steps {
+		e := new(Engine)
+		e.Initialize(&Config{})
+		e.InstallQueue(NewLocalQueue())
+		e.InstallDownloader(&panicDownloader{panics: step.downloader})
+
+		var calls []string
+		var processed bool
+		call := func(name string) error {
+			calls = append(calls, name)
+			if name == step.panicAt {
+				panic(name)
+			}
+			if name == step.failAt {
+				return errors.New(name)
+			}
+			return nil
+		}
+		for _, n := range []string{"1", "2"} {
+			n := n
+			e.InstallMiddleware(&MiddlewareFuncs{
+				Before: func(*Task) error { return call("before " + n) },
+				After:  func(*DownloadResult) error { return call("after " + n) },
+				Error:  func(*DownloadResult) { call("error " + n) },
+			})
+		}
+		e.InstallProcessor(func(*DownloadResult, *Engine) error {
+			processed = true
+			return nil
+		}, "page")
+
+		e.runTask(&Task{URL: "http://example.com/", Method: "GET", ProcessorName: "page"})
+
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt64(e.downloadingCount) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("step %d: expect the result to be handled", i)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		if strings.Join(calls, ",") != step.calls {
+			t.Errorf("step %d: expect calls %s, got %s", i, step.calls, strings.Join(calls, ","))
+		}
+		if processed != step.processed {
+			t.Errorf("step %d: expect processed %v, got %v", i, step.processed, processed)
+		}
+	}
+}
+
+// basicQueue only implements the methods of Queue.
+type basicQueue struct {
+	queue *LocalQueue
+}
+
+func (q *basicQueue) Shutdown() {}
+
+func (q *basicQueue) Enqueue(item *Task, allowDuplication bool, position EnqueuePosition) error {
+	return q.queue.Enqueue(item, allowDuplication, position)
+}
+
+func (q *basicQueue) Pop() (*Task, error) {
+	return q.queue.Pop()
+}
+
+func (q *basicQueue) Len() (int64, error) {
+	return q.queue.Len()
+}
+
+func TestEngineOptionalQueueMethods(t *testing.T) {
+	task := &Task{URL: "http://example.com/", Method: "GET", ProcessorName: "page"}
+	tests := []struct {
+		queue  Queue
+		forget error
+	}{
+		{NewLocalQueue(), nil},
+		{&basicQueue{queue: NewLocalQueue()}, ErrQueueForgetNotSupported},
+	}
+
+	for i, test := range tests {
+		config := &Config{}
+		config.Request.DeduplicateFinalURL = true
+		e := new(Engine)
+		e.Initialize(config)
+		e.InstallQueue(test.queue)
+
+		if err := e.ForgetTask(task); err != test.forget {
+			t.Errorf("queue %d: expect %v, got %v", i, test.forget, err)
+		}
+
+		// a queue without MarkVisited skips the check of final URLs
+		result := &DownloadResult{Task: task, URL: "http://example.com/final", Redirects: []Redirect{{}}}
+		if e.duplicatedFinalURL(result) {
+			t.Errorf("queue %d: expect the first final URL not to be duplicated", i)
+		}
+		_, marks := test.queue.(visitMarker)
+		if duplicated := e.duplicatedFinalURL(result); duplicated != marks {
+			t.Errorf("queue %d: expect the second final URL duplicated %v, got %v", i, marks, duplicated)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package krawler
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LinkRule defines which links found in a page should be followed and which
+// processor they should be handed to.
+type LinkRule struct {
+	// Allow is a list of regular expressions. A link is only followed if it
+	// matches at least one of them. An empty list allows every link.
+	Allow []string
+
+	// Deny is a list of regular expressions. A link matching any of them is ignored.
+	// Deny takes precedence over Allow.
+	Deny []string
+
+	// AllowDomains restricts links to these domains and their sub-domains.
+	AllowDomains []string
+
+	// DenyDomains ignores links to these domains and their sub-domains.
+	DenyDomains []string
+
+	// Selectors restricts extraction to the parts of the document matched by these
+	// CSS selectors, which may also select the links themselves. An empty list scans
+	// the whole document.
+	Selectors []string
+
+	// SkipNofollow ignores links marked with rel="nofollow".
+	SkipNofollow bool
+
+	// ProcessorName is the processor alias of tasks created from matched links.
+	ProcessorName string
+
+	// Method is the HTTP method of created tasks. Default to GET.
+	Method string
+
+	// AllowDuplication is copied to created tasks.
+	AllowDuplication bool
+}
+
+// LinkExtractor extracts links from HTML documents and turns them into tasks
+// according to a series of rules. A link is claimed by the first rule it matches.
+type LinkExtractor struct {
+	rules []*linkRule
+}
+
+type linkRule struct {
+	LinkRule
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// linkSelector selects elements which carry a link.
+const linkSelector = "a[href], area[href]"
+
+// NewLinkExtractor compiles rules and creates a link extractor.
+func NewLinkExtractor(rules ...LinkRule) (*LinkExtractor, error) {
+	extractor := &LinkExtractor{}
+
+	for i, rule := range rules {
+		if rule.ProcessorName == "" {
+			return nil, fmt.Errorf("rule %d has no processor name", i)
+		}
+		if rule.Method == "" {
+			rule.Method = "GET"
+		}
+
+		compiled := &linkRule{LinkRule: rule}
+		for _, pattern := range rule.Allow {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d has an invalid allow pattern %q, reason: %v", i, pattern, err)
+			}
+			compiled.allow = append(compiled.allow, re)
+		}
+		for _, pattern := range rule.Deny {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d has an invalid deny pattern %q, reason: %v", i, pattern, err)
+			}
+			compiled.deny = append(compiled.deny, re)
+		}
+
+		extractor.rules = append(extractor.rules, compiled)
+	}
+
+	return extractor, nil
+}
+
+// Extract parses the download result as HTML and returns tasks for every link
+// that is matched by a rule. Relative links are resolved against the URL of the result.
+func (x *LinkExtractor) Extract(result *DownloadResult) ([]*Task, error) {
+	base, err := resultURL(result)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := result.HTML()
+	if err != nil {
+		return nil, err
+	}
+
+	if href, exists := doc.Find("base[href]").First().Attr("href"); exists {
+		if baseHref, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = baseHref
+		}
+	}
+
+	var tasks []*Task
+	claimed := make(map[string]bool)
+	for _, rule := range x.rules {
+		for _, link := range rule.links(doc, base) {
+			if claimed[link] || !rule.match(link) {
+				continue
+			}
+			claimed[link] = true
+
+			tasks = append(tasks, &Task{
+				URL:              link,
+				Method:           rule.Method,
+				ProcessorName:    rule.ProcessorName,
+				AllowDuplication: rule.AllowDuplication,
+			})
+		}
+	}
+
+	return tasks, nil
+}
+
+// Follow wraps a processor so that links extracted from the download result are
+// added to the engine after the processor succeeds. processor can be nil if the
+// page is only used for crawling.
+func (x *LinkExtractor) Follow(processor FuncProcessor) FuncProcessor {
+	return func(result *DownloadResult, engine *Engine) error {
+		if processor != nil {
+			if err := processor(result, engine); err != nil {
+				return err
+			}
+		}
+
+		tasks, err := x.Extract(result)
+		if err != nil {
+			return err
+		}
+
+		engine.AddTask(tasks...)
+		return nil
+	}
+}
+
+// links returns the absolute URLs of links inside the scope of the rule. Links are
+// kept if they are http or https, or have the same scheme as the page, like links
+// between local files.
+func (r *linkRule) links(doc *goquery.Document, base *url.URL) []string {
+	var selection *goquery.Selection
+	if len(r.Selectors) == 0 {
+		selection = doc.Find(linkSelector)
+	} else {
+		scope := doc.Find(strings.Join(r.Selectors, ", "))
+		selection = scope.Find(linkSelector).AddSelection(scope.Filter(linkSelector))
+	}
+
+	var links []string
+	selection.Each(func(_ int, s *goquery.Selection) {
+		if r.SkipNofollow && nofollow(s) {
+			return
+		}
+
+		href, _ := s.Attr("href")
+		link, err := base.Parse(strings.TrimSpace(href))
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https" && link.Scheme != base.Scheme) {
+			return
+		}
+
+		link.Fragment = ""
+		links = append(links, link.String())
+	})
+
+	return links
+}
+
+// nofollow tells whether the rel attribute of a link has the nofollow keyword.
+func nofollow(s *goquery.Selection) bool {
+	rel, _ := s.Attr("rel")
+	for _, keyword := range strings.Fields(rel) {
+		if strings.EqualFold(keyword, "nofollow") {
+			return true
+		}
+	}
+	return false
+}
+
+// match checks a link against the patterns and domains of the rule.
+func (r *linkRule) match(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if len(r.AllowDomains) > 0 && !matchDomains(host, r.AllowDomains) {
+		return false
+	}
+	if matchDomains(host, r.DenyDomains) {
+		return false
+	}
+
+	for _, re := range r.deny {
+		if re.MatchString(link) {
+			return false
+		}
+	}
+
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, re := range r.allow {
+		if re.MatchString(link) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchDomains reports whether host is one of domains or a sub-domain of them.
+func matchDomains(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
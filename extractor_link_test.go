@@ -0,0 +1,137 @@
+package krawler
+
+import (
+	"strings"
+	"testing"
+)
+
+const linkTestPage = `<html><head><base href="/docs/"></head><body>
+<nav><a href="/">Home</a> <a class="next" href="page/2">Next</a></nav>
+<div class="content">
+	<a href="guide.html#intro">Guide</a>
+	<a href="https://blog.example.com/post/1">Post</a>
+	<a href="https://ads.example.com/click" rel="sponsored nofollow">Ad</a>
+	<a href="https://other.com/">Other</a>
+	<a href="mailto:someone@example.com">Mail</a>
+	<a href="javascript:void(0)">Script</a>
+	<map><area href="/map/1"></map>
+</div>
+</body></html>`
+
+func TestLinkExtractor(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []LinkRule
+		links []string
+	}{
+		{
+			"every link",
+			[]LinkRule{{ProcessorName: "page"}},
+			[]string{
+				"http://example.com/",
+				"http://example.com/docs/page/2",
+				"http://example.com/docs/guide.html",
+				"https://blog.example.com/post/1",
+				"https://ads.example.com/click",
+				"https://other.com/",
+				"http://example.com/map/1",
+			},
+		},
+		{
+			"selector scoping descendants",
+			[]LinkRule{{ProcessorName: "page", Selectors: []string{"nav"}}},
+			[]string{"http://example.com/", "http://example.com/docs/page/2"},
+		},
+		{
+			"selector matching links themselves",
+			[]LinkRule{{ProcessorName: "page", Selectors: []string{"a.next"}}},
+			[]string{"http://example.com/docs/page/2"},
+		},
+		{
+			"allow and deny patterns",
+			[]LinkRule{{ProcessorName: "page", Allow: []string{`/docs/`, `/post/`}, Deny: []string{`page/\d+$`}}},
+			[]string{"http://example.com/docs/guide.html", "https://blog.example.com/post/1"},
+		},
+		{
+			"allowed domains include sub-domains",
+			[]LinkRule{{ProcessorName: "page", AllowDomains: []string{"blog.example.com", "other.com"}, DenyDomains: []string{"other.com"}}},
+			[]string{"https://blog.example.com/post/1"},
+		},
+		{
+			"denied sub-domains",
+			[]LinkRule{{ProcessorName: "page", AllowDomains: []string{".example.com"}, DenyDomains: []string{"ads.example.com"}}},
+			[]string{
+				"http://example.com/",
+				"http://example.com/docs/page/2",
+				"http://example.com/docs/guide.html",
+				"https://blog.example.com/post/1",
+				"http://example.com/map/1",
+			},
+		},
+		{
+			"nofollow skipped",
+			[]LinkRule{{ProcessorName: "page", Selectors: []string{".content"}, SkipNofollow: true, DenyDomains: []string{"other.com"}}},
+			[]string{"http://example.com/docs/guide.html", "https://blog.example.com/post/1", "http://example.com/map/1"},
+		},
+		{
+			"first matching rule claims a link",
+			[]LinkRule{
+				{ProcessorName: "post", Allow: []string{`/post/`}},
+				{ProcessorName: "page", AllowDomains: []string{"blog.example.com", "other.com"}},
+			},
+			[]string{"post https://blog.example.com/post/1", "page https://other.com/"},
+		},
+	}
+
+	for _, test := range tests {
+		extractor, err := NewLinkExtractor(test.rules...)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		result := &DownloadResult{
+			URL:     "http://example.com/index.html",
+			Content: []byte(linkTestPage),
+			Task:    &Task{URL: "http://example.com/", Method: "GET"},
+		}
+		tasks, err := extractor.Extract(result)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		var links []string
+		for _, task := range tasks {
+			if len(test.rules) > 1 {
+				links = append(links, task.ProcessorName+" "+task.URL)
+			} else {
+				links = append(links, task.URL)
+			}
+		}
+		if strings.Join(links, "\n") != strings.Join(test.links, "\n") {
+			t.Errorf("%s: expect links\n%s\ngot\n%s", test.name, strings.Join(test.links, "\n"), strings.Join(links, "\n"))
+		}
+	}
+}
+
+func TestNewLinkExtractor(t *testing.T) {
+	tests := []struct {
+		rule LinkRule
+		fail bool
+	}{
+		{LinkRule{ProcessorName: "page"}, false},
+		{LinkRule{}, true},
+		{LinkRule{ProcessorName: "page", Allow: []string{"("}}, true},
+		{LinkRule{ProcessorName: "page", Deny: []string{"["}}, true},
+	}
+
+	for i, test := range tests {
+		extractor, err := NewLinkExtractor(test.rule)
+		if (err != nil) != test.fail {
+			t.Errorf("rule %d: expect failure %v, got error %v", i, test.fail, err)
+			continue
+		}
+		if !test.fail && extractor.rules[0].Method != "GET" {
+			t.Errorf("rule %d: expect method GET by default, got %s", i, extractor.rules[0].Method)
+		}
+	}
+}
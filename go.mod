@@ -3,12 +3,22 @@ module github.com/thagki9/krawler
 go 1.12
 
 require (
+	github.com/PuerkitoBio/goquery v1.5.0
+	github.com/andybalholm/brotli v1.0.0
+	github.com/andybalholm/cascadia v1.0.0
+	github.com/antchfx/htmlquery v1.0.0
+	github.com/antchfx/xmlquery v1.0.0
+	github.com/antchfx/xpath v1.0.0
 	github.com/go-redis/redis v6.15.2+incompatible
 	github.com/json-iterator/go v1.1.6
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/oliveagle/jsonpath v0.0.0-20180606110733-2e52cf6e6852
 	github.com/onsi/ginkgo v1.8.0 // indirect
 	github.com/onsi/gomega v1.5.0 // indirect
 	github.com/rifflock/lfshook v0.0.0-20180920164130-b9218ef580f5
 	github.com/sirupsen/logrus v1.3.0
+	golang.org/x/net v0.0.0-20181114220301-adae6a3d119a
+	golang.org/x/text v0.3.0
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,169 @@
+package krawler
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// User-Agent rotations of HeadersConfig
+const (
+	RotationRoundRobin = "round-robin"
+	RotationRandom     = "random"
+	RotationSticky     = "sticky"
+)
+
+// HeaderProfile is a set of headers that is consistent with a browser.
+type HeaderProfile struct {
+	UserAgents []string
+	Headers    http.Header
+}
+
+// HeaderProfiles holds the header profiles that can be referred by
+// HeadersConfig.Profile. More profiles can be registered before creating
+// downloaders.
+var HeaderProfiles = map[string]HeaderProfile{
+	"chrome": {
+		UserAgents: []string{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/74.0.3729.131 Safari/537.36",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/74.0.3729.131 Safari/537.36",
+			"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/74.0.3729.131 Safari/537.36",
+		},
+		Headers: http.Header{
+			"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8"},
+			"Accept-Language":           {"en-US,en;q=0.9"},
+			"Upgrade-Insecure-Requests": {"1"},
+		},
+	},
+	"firefox": {
+		UserAgents: []string{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:66.0) Gecko/20100101 Firefox/66.0",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.14; rv:66.0) Gecko/20100101 Firefox/66.0",
+			"Mozilla/5.0 (X11; Linux x86_64; rv:66.0) Gecko/20100101 Firefox/66.0",
+		},
+		Headers: http.Header{
+			"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			"Accept-Language":           {"en-US,en;q=0.5"},
+			"Upgrade-Insecure-Requests": {"1"},
+		},
+	},
+	"safari": {
+		UserAgents: []string{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1 Safari/605.1.15",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 12_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1 Mobile/15E148 Safari/604.1",
+		},
+		Headers: http.Header{
+			"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			"Accept-Language": {"en-us"},
+		},
+	},
+}
+
+// headerBuilder builds headers of requests according to HeadersConfig.
+type headerBuilder struct {
+	profile    http.Header
+	userAgents []string
+	rotation   string
+	defaults   http.Header
+	hosts      map[string]http.Header
+
+	mutex  sync.Mutex
+	next   int
+	sticky map[string]string
+}
+
+func newHeaderBuilder(request RequestConfig, config HeadersConfig) (*headerBuilder, error) {
+	b := &headerBuilder{
+		userAgents: config.UserAgents,
+		rotation:   config.Rotation,
+		defaults:   config.Default,
+		hosts:      make(map[string]http.Header),
+		sticky:     make(map[string]string),
+	}
+
+	if config.Profile != "" {
+		profile, exists := HeaderProfiles[config.Profile]
+		if !exists {
+			return nil, fmt.Errorf("unknown header profile %s", config.Profile)
+		}
+		b.profile = profile.Headers
+		if len(b.userAgents) == 0 {
+			b.userAgents = profile.UserAgents
+		}
+	}
+	if len(b.userAgents) == 0 {
+		b.userAgents = []string{request.UserAgent}
+	}
+
+	switch b.rotation {
+	case "":
+		b.rotation = RotationRoundRobin
+	case RotationRoundRobin, RotationRandom, RotationSticky:
+	default:
+		return nil, fmt.Errorf("unknown user agent rotation %s", config.Rotation)
+	}
+
+	for host, header := range config.Hosts {
+		b.hosts[strings.ToLower(strings.TrimPrefix(host, "."))] = header
+	}
+
+	return b, nil
+}
+
+// userAgent picks a User-Agent from the pool for a request to host.
+func (b *headerBuilder) userAgent(host string) string {
+	if len(b.userAgents) == 1 {
+		return b.userAgents[0]
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.rotation {
+	case RotationRandom:
+		return b.userAgents[rand.Intn(len(b.userAgents))]
+	case RotationSticky:
+		if userAgent, exists := b.sticky[host]; exists {
+			return userAgent
+		}
+	}
+
+	userAgent := b.userAgents[b.next%len(b.userAgents)]
+	b.next++
+	if b.rotation == RotationSticky {
+		b.sticky[host] = userAgent
+	}
+	return userAgent
+}
+
+// build returns the headers of a request to host. Layers are applied from the
+// lowest priority to the highest, and a layer replaces every value of the fields
+// it sets:
+//  1. headers of the browser profile
+//  2. the User-Agent picked from the pool
+//  3. default headers
+//  4. headers of the host and its parent domains, more specific ones later
+//  5. headers of the task
+func (b *headerBuilder) build(host string, task *Task) http.Header {
+	header := make(http.Header)
+	set := func(layer http.Header) {
+		for field, values := range layer {
+			header[http.CanonicalHeaderKey(field)] = values
+		}
+	}
+
+	host = strings.ToLower(host)
+	set(b.profile)
+	header.Set("User-Agent", b.userAgent(host))
+	set(b.defaults)
+
+	labels := strings.Split(host, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		set(b.hosts[strings.Join(labels[i:], ".")])
+	}
+
+	set(task.Headers)
+	return header
+}
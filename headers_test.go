@@ -0,0 +1,177 @@
+package krawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewHeaderBuilder(t *testing.T) {
+	request := RequestConfig{UserAgent: "krawler"}
+	tests := []struct {
+		config     HeadersConfig
+		userAgents []string
+		fail       string
+	}{
+		{HeadersConfig{}, []string{"krawler"}, ""},
+		{HeadersConfig{UserAgents: []string{"a", "b"}}, []string{"a", "b"}, ""},
+		{HeadersConfig{Profile: "safari"}, HeaderProfiles["safari"].UserAgents, ""},
+		{HeadersConfig{Profile: "safari", UserAgents: []string{"a"}}, []string{"a"}, ""},
+		{HeadersConfig{Profile: "lynx"}, nil, "unknown header profile lynx"},
+		{HeadersConfig{Rotation: "weighted"}, nil, "unknown user agent rotation weighted"},
+	}
+
+	for i, test := range tests {
+		b, err := newHeaderBuilder(request, test.config)
+		if test.fail != "" {
+			if err == nil || err.Error() != test.fail {
+				t.Errorf("config %d: expect error %q, got %v", i, test.fail, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("config %d: expect no error, got %v", i, err)
+			continue
+		}
+		if strings.Join(b.userAgents, "|") != strings.Join(test.userAgents, "|") {
+			t.Errorf("config %d: expect user agents %v, got %v", i, test.userAgents, b.userAgents)
+		}
+		if b.rotation != RotationRoundRobin {
+			t.Errorf("config %d: expect round-robin by default, got %s", i, b.rotation)
+		}
+	}
+}
+
+func TestHeaderBuilderUserAgent(t *testing.T) {
+	userAgents := []string{"a", "b", "c"}
+	tests := []struct {
+		rotation string
+		hosts    []string
+		expected string
+	}{
+		{RotationRoundRobin, []string{"x", "x", "y", "x"}, "a,b,c,a"},
+		{RotationSticky, []string{"x", "y", "x", "z", "y", "w"}, "a,b,a,c,b,a"},
+	}
+
+	for _, test := range tests {
+		b, err := newHeaderBuilder(RequestConfig{}, HeadersConfig{UserAgents: userAgents, Rotation: test.rotation})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var picked []string
+		for _, host := range test.hosts {
+			picked = append(picked, b.userAgent(host))
+		}
+		if strings.Join(picked, ",") != test.expected {
+			t.Errorf("%s %v: expect %s, got %s", test.rotation, test.hosts, test.expected, strings.Join(picked, ","))
+		}
+	}
+
+	b, _ := newHeaderBuilder(RequestConfig{}, HeadersConfig{UserAgents: userAgents, Rotation: RotationRandom})
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		seen[b.userAgent("x")] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("expect random user agents, got %v", seen)
+	}
+}
+
+func TestHeaderBuilderBuild(t *testing.T) {
+	HeaderProfiles["test"] = HeaderProfile{
+		UserAgents: []string{"profile"},
+		Headers: http.Header{
+			"Accept":          {"text/html"},
+			"Accept-Language": {"en"},
+			"X-Layer":         {"profile"},
+		},
+	}
+	defer delete(HeaderProfiles, "test")
+
+	b, err := newHeaderBuilder(RequestConfig{UserAgent: "krawler"}, HeadersConfig{
+		Profile: "test",
+		Default: http.Header{
+			"x-layer": {"default"},
+			"X-Multi": {"1", "2"},
+		},
+		Hosts: map[string]http.Header{
+			".example.com":     {"X-Layer": {"example.com"}, "User-Agent": {"host"}},
+			"api.example.com":  {"X-Layer": {"api.example.com"}, "X-Multi": {"3"}},
+			"deep.example.com": {"X-Deep": {"1"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host     string
+		task     http.Header
+		expected http.Header
+	}{
+		{"other.com", nil, http.Header{
+			"Accept": {"text/html"}, "Accept-Language": {"en"}, "User-Agent": {"profile"},
+			"X-Layer": {"default"}, "X-Multi": {"1", "2"},
+		}},
+		{"www.example.com", nil, http.Header{
+			"Accept": {"text/html"}, "Accept-Language": {"en"}, "User-Agent": {"host"},
+			"X-Layer": {"example.com"}, "X-Multi": {"1", "2"},
+		}},
+		{"API.Example.com", nil, http.Header{
+			"Accept": {"text/html"}, "Accept-Language": {"en"}, "User-Agent": {"host"},
+			"X-Layer": {"api.example.com"}, "X-Multi": {"3"},
+		}},
+		{"api.example.com", http.Header{"x-multi": {"4"}, "Accept": {"application/json"}}, http.Header{
+			"Accept": {"application/json"}, "Accept-Language": {"en"}, "User-Agent": {"host"},
+			"X-Layer": {"api.example.com"}, "X-Multi": {"4"},
+		}},
+	}
+
+	for _, test := range tests {
+		header := b.build(test.host, &Task{Headers: test.task})
+		if len(header) != len(test.expected) {
+			t.Errorf("%s: expect %v, got %v", test.host, test.expected, header)
+			continue
+		}
+		for field, values := range test.expected {
+			if strings.Join(header[field], ",") != strings.Join(values, ",") {
+				t.Errorf("%s: expect %s %v, got %v", test.host, field, values, header[field])
+			}
+		}
+	}
+}
+
+func TestHTTPDownloaderHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.UserAgent() + "|" + r.Header.Get("X-Token")))
+	}))
+	defer server.Close()
+
+	config := GetDefaultConfig()
+	config.Headers.UserAgents = []string{"a", "b"}
+	config.Headers.Default = http.Header{"X-Token": {"default"}}
+	downloader := NewHTTPDownloader(config)
+	defer downloader.Shutdown()
+
+	tests := []struct {
+		headers  http.Header
+		expected string
+	}{
+		{nil, "a|default"},
+		{http.Header{"X-Token": {"task"}}, "b|task"},
+		{http.Header{"User-Agent": {"task"}}, "task|default"},
+	}
+
+	for _, test := range tests {
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: server.URL, Method: http.MethodGet, Headers: test.headers}, ch)
+		result := <-ch
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if string(result.Content) != test.expected {
+			t.Errorf("%v: expect %s, got %s", test.headers, test.expected, result.Content)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package krawler
+
+// DownloaderMiddleware hooks into the downloading of every task. Middlewares are
+// installed on the engine and work with any downloader.
+type DownloaderMiddleware interface {
+	// BeforeDownload is called before a task is handed to the downloader and may
+	// modify the task. Returning an error fails the download without sending it.
+	BeforeDownload(task *Task) error
+
+	// AfterDownload is called after a task is downloaded successfully and may modify
+	// the result. Returning an error turns the result into a failure.
+	AfterDownload(result *DownloadResult) error
+
+	// OnDownloadError is called after a task fails to download. It may recover from
+	// the failure by setting result.Err to nil.
+	OnDownloadError(result *DownloadResult)
+}
+
+// MiddlewareFuncs implements DownloaderMiddleware with functions. A nil function
+// is skipped.
+type MiddlewareFuncs struct {
+	Before func(*Task) error
+	After  func(*DownloadResult) error
+	Error  func(*DownloadResult)
+}
+
+// BeforeDownload implements DownloaderMiddleware#BeforeDownload
+func (m *MiddlewareFuncs) BeforeDownload(task *Task) error {
+	if m.Before == nil {
+		return nil
+	}
+	return m.Before(task)
+}
+
+// AfterDownload implements DownloaderMiddleware#AfterDownload
+func (m *MiddlewareFuncs) AfterDownload(result *DownloadResult) error {
+	if m.After == nil {
+		return nil
+	}
+	return m.After(result)
+}
+
+// OnDownloadError implements DownloaderMiddleware#OnDownloadError
+func (m *MiddlewareFuncs) OnDownloadError(result *DownloadResult) {
+	if m.Error != nil {
+		m.Error(result)
+	}
+}
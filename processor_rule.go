@@ -0,0 +1,723 @@
+package krawler
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/xpath"
+	json "github.com/json-iterator/go"
+	"github.com/oliveagle/jsonpath"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// RuleDefinition defines the structure of a YAML or JSON file which declares a
+// RuleProcessor.
+type RuleDefinition struct {
+	// Name is the processor alias the rule processor is installed under.
+	Name string `yaml:"name" json:"name"`
+
+	// URLs is a list of regular expressions. Items are only extracted from pages whose
+	// final URL, after redirects, matches one of them. An empty list matches every page.
+	URLs []string `yaml:"urls" json:"urls"`
+
+	// Scope selects the elements that each produce an item. If it is empty, the
+	// whole page produces a single item.
+	Scope RuleSelector `yaml:"scope" json:"scope"`
+
+	// Fields defines the fields of an item.
+	Fields []FieldRule `yaml:"fields" json:"fields"`
+
+	// Follow defines links that should be followed.
+	Follow []FollowRule `yaml:"follow" json:"follow"`
+
+	// Pagination selects the link to the next page, which is processed by this
+	// processor again.
+	Pagination RuleSelector `yaml:"pagination" json:"pagination"`
+}
+
+// RuleSelector selects values from a page. Exactly one of CSS, XPath and JSONPath
+// can be set. Regex is applied on the selected values, or on the page content if no
+// other selector is set, and the first sub-match is used if the expression has one.
+type RuleSelector struct {
+	CSS      string `yaml:"css" json:"css"`
+	XPath    string `yaml:"xpath" json:"xpath"`
+	JSONPath string `yaml:"jsonpath" json:"jsonpath"`
+	Regex    string `yaml:"regex" json:"regex"`
+
+	// Attr reads an attribute instead of the text of elements selected by CSS or XPath.
+	Attr string `yaml:"attr" json:"attr"`
+}
+
+// FieldRule defines how a field of an item is extracted and converted.
+type FieldRule struct {
+	RuleSelector `yaml:",inline"`
+
+	Name string `yaml:"name" json:"name"`
+
+	// Type is one of string, int, float, bool and time. Default to string.
+	Type string `yaml:"type" json:"type"`
+
+	// Layout is the layout used to parse a field of type time.
+	Layout string `yaml:"layout" json:"layout"`
+
+	// Multiple keeps every selected value instead of the first one.
+	Multiple bool `yaml:"multiple" json:"multiple"`
+
+	// Required fails processing if the field has no value.
+	Required bool `yaml:"required" json:"required"`
+}
+
+// FollowRule is the declarative form of LinkRule.
+type FollowRule struct {
+	Allow            []string `yaml:"allow" json:"allow"`
+	Deny             []string `yaml:"deny" json:"deny"`
+	AllowDomains     []string `yaml:"allow_domains" json:"allow_domains"`
+	DenyDomains      []string `yaml:"deny_domains" json:"deny_domains"`
+	Selectors        []string `yaml:"selectors" json:"selectors"`
+	SkipNofollow     bool     `yaml:"skip_nofollow" json:"skip_nofollow"`
+	Processor        string   `yaml:"processor" json:"processor"`
+	Method           string   `yaml:"method" json:"method"`
+	AllowDuplication bool     `yaml:"allow_duplication" json:"allow_duplication"`
+}
+
+// strictJSON rejects unknown fields so that typos in a definition are reported.
+var strictJSON = json.Config{DisallowUnknownFields: true}.Froze()
+
+// RuleError reports a mistake in a rule definition.
+type RuleError struct {
+	File   string
+	Field  string
+	Reason string
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.File, e.Field, e.Reason)
+}
+
+// Item is a structured record extracted by a RuleProcessor.
+type Item map[string]interface{}
+
+// ItemHandler receives items extracted by a RuleProcessor.
+type ItemHandler = func(Item, *DownloadResult, *Engine) error
+
+// RuleProcessor is a processor built from a RuleDefinition. It is installed by
+// engine.InstallProcessor(processor.Process, processor.Name).
+type RuleProcessor struct {
+	Name string
+
+	urls       []*regexp.Regexp
+	scope      *compiledSelector
+	fields     []*compiledField
+	follow     *LinkExtractor
+	pagination *compiledSelector
+	handler    ItemHandler
+}
+
+type compiledSelector struct {
+	RuleSelector
+	regex *regexp.Regexp
+}
+
+type compiledField struct {
+	FieldRule
+	selector *compiledSelector
+}
+
+// LoadRuleProcessor reads a rule definition from a YAML or JSON file and builds a
+// rule processor from it.
+func LoadRuleProcessor(path string) (*RuleProcessor, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rule definition %s failed, reason: %v", path, err)
+	}
+
+	definition := new(RuleDefinition)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := checkJSONDefinition(raw, path); err != nil {
+			return nil, err
+		}
+		err = strictJSON.Unmarshal(raw, definition)
+	default:
+		err = yaml.UnmarshalStrict(raw, definition)
+	}
+	if err != nil {
+		return nil, &RuleError{File: path, Field: "-", Reason: err.Error()}
+	}
+
+	return NewRuleProcessor(definition, path)
+}
+
+// checkJSONDefinition reports a syntax error of a JSON definition with its line,
+// and a value of a wrong type or an unknown field with its path, which the errors
+// of the JSON decoder do not tell.
+func checkJSONDefinition(raw []byte, source string) error {
+	var value interface{}
+	if err := stdjson.Unmarshal(raw, &value); err != nil {
+		if syntaxErr, ok := err.(*stdjson.SyntaxError); ok {
+			line := 1 + strings.Count(string(raw[:syntaxErr.Offset]), "\n")
+			return &RuleError{File: source, Field: fmt.Sprintf("line %d", line), Reason: syntaxErr.Error()}
+		}
+		return &RuleError{File: source, Field: "-", Reason: err.Error()}
+	}
+
+	if field, reason := checkJSONValue(value, reflect.TypeOf(RuleDefinition{}), ""); field != "" {
+		return &RuleError{File: source, Field: field, Reason: reason}
+	}
+	return nil
+}
+
+// checkJSONValue checks a decoded JSON value against the type it is decoded into,
+// and returns the path of the first mismatch and the reason.
+func checkJSONValue(value interface{}, t reflect.Type, path string) (string, string) {
+	if value == nil {
+		return "", ""
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return pathOrRoot(path), fmt.Sprintf("expects an object, got %s", jsonKind(value))
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			field, found := jsonField(t, key)
+			if !found {
+				return fieldPath, "unknown field"
+			}
+			if mismatch, reason := checkJSONValue(object[key], field.Type, fieldPath); mismatch != "" {
+				return mismatch, reason
+			}
+		}
+
+	case reflect.Slice:
+		array, ok := value.([]interface{})
+		if !ok {
+			return pathOrRoot(path), fmt.Sprintf("expects a list, got %s", jsonKind(value))
+		}
+		for i, element := range array {
+			if mismatch, reason := checkJSONValue(element, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); mismatch != "" {
+				return mismatch, reason
+			}
+		}
+
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return pathOrRoot(path), fmt.Sprintf("expects a string, got %s", jsonKind(value))
+		}
+
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return pathOrRoot(path), fmt.Sprintf("expects a boolean, got %s", jsonKind(value))
+		}
+	}
+
+	return "", ""
+}
+
+// jsonField finds the field of a struct decoded from a JSON key, looking into
+// embedded structs. Keys are matched case-insensitively like the JSON decoder.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous && name == "" {
+			if embedded, found := jsonField(field.Type, key); found {
+				return embedded, true
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if name != "-" && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "-"
+	}
+	return path
+}
+
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	default:
+		return "a number"
+	}
+}
+
+// NewRuleProcessor validates a rule definition and builds a rule processor from it.
+// source names where the definition comes from and is used in errors.
+func NewRuleProcessor(definition *RuleDefinition, source string) (*RuleProcessor, error) {
+	fail := func(field string, format string, args ...interface{}) error {
+		return &RuleError{File: source, Field: field, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if definition.Name == "" {
+		return nil, fail("name", "name is required")
+	}
+
+	processor := &RuleProcessor{
+		Name:    definition.Name,
+		handler: logItem,
+	}
+
+	for i, pattern := range definition.URLs {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fail(fmt.Sprintf("urls[%d]", i), "invalid pattern %q, reason: %v", pattern, err)
+		}
+		processor.urls = append(processor.urls, re)
+	}
+
+	var err error
+	if processor.scope, err = compileSelector(definition.Scope, "scope", fail); err != nil {
+		return nil, err
+	}
+	if processor.scope != nil && processor.scope.Regex != "" {
+		return nil, fail("scope.regex", "regex is not supported by scope")
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range definition.Fields {
+		path := fmt.Sprintf("fields[%d]", i)
+		if rule.Name == "" {
+			return nil, fail(path+".name", "name is required")
+		}
+		if names[rule.Name] {
+			return nil, fail(path+".name", "field %q is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Type {
+		case "":
+			rule.Type = "string"
+		case "string", "int", "float", "bool":
+		case "time":
+			if rule.Layout == "" {
+				return nil, fail(path+".layout", "layout is required by type time")
+			}
+		default:
+			return nil, fail(path+".type", "unknown type %q", rule.Type)
+		}
+
+		selector, err := compileSelector(rule.RuleSelector, path, fail)
+		if err != nil {
+			return nil, err
+		}
+		if selector == nil {
+			return nil, fail(path, "one of css, xpath, jsonpath and regex is required")
+		}
+
+		processor.fields = append(processor.fields, &compiledField{FieldRule: rule, selector: selector})
+	}
+
+	var linkRules []LinkRule
+	for i, rule := range definition.Follow {
+		if rule.Processor == "" {
+			return nil, fail(fmt.Sprintf("follow[%d].processor", i), "processor is required")
+		}
+		linkRules = append(linkRules, LinkRule{
+			Allow:            rule.Allow,
+			Deny:             rule.Deny,
+			AllowDomains:     rule.AllowDomains,
+			DenyDomains:      rule.DenyDomains,
+			Selectors:        rule.Selectors,
+			SkipNofollow:     rule.SkipNofollow,
+			ProcessorName:    rule.Processor,
+			Method:           rule.Method,
+			AllowDuplication: rule.AllowDuplication,
+		})
+	}
+	if len(linkRules) > 0 {
+		if processor.follow, err = NewLinkExtractor(linkRules...); err != nil {
+			return nil, fail("follow", "%v", err)
+		}
+	}
+
+	if processor.pagination, err = compileSelector(definition.Pagination, "pagination", fail); err != nil {
+		return nil, err
+	}
+
+	return processor, nil
+}
+
+func compileSelector(selector RuleSelector, path string, fail func(string, string, ...interface{}) error) (*compiledSelector, error) {
+	count := 0
+	for _, expr := range []string{selector.CSS, selector.XPath, selector.JSONPath} {
+		if expr != "" {
+			count++
+		}
+	}
+	if count > 1 {
+		return nil, fail(path, "only one of css, xpath and jsonpath can be set")
+	}
+	if count == 0 && selector.Regex == "" {
+		return nil, nil
+	}
+
+	compiled := &compiledSelector{RuleSelector: selector}
+	if selector.CSS != "" {
+		if _, err := cascadia.Compile(selector.CSS); err != nil {
+			return nil, fail(path+".css", "invalid selector %q, reason: %v", selector.CSS, err)
+		}
+	}
+	if selector.XPath != "" {
+		if _, err := xpath.Compile(selector.XPath); err != nil {
+			return nil, fail(path+".xpath", "invalid expression %q, reason: %v", selector.XPath, err)
+		}
+	}
+	if selector.JSONPath != "" {
+		if _, err := jsonpath.Compile(selector.JSONPath); err != nil {
+			return nil, fail(path+".jsonpath", "invalid expression %q, reason: %v", selector.JSONPath, err)
+		}
+	}
+	if selector.Regex != "" {
+		re, err := regexp.Compile(selector.Regex)
+		if err != nil {
+			return nil, fail(path+".regex", "invalid pattern %q, reason: %v", selector.Regex, err)
+		}
+		compiled.regex = re
+	}
+
+	return compiled, nil
+}
+
+// OnItem sets the handler of extracted items. Items are logged by default.
+func (p *RuleProcessor) OnItem(handler ItemHandler) {
+	p.handler = handler
+}
+
+// Process implements FuncProcessor.
+func (p *RuleProcessor) Process(result *DownloadResult, engine *Engine) error {
+	if p.matchURL(finalURL(result)) {
+		items, err := p.extractItems(result)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if err := p.handler(item, result, engine); err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.follow != nil {
+		tasks, err := p.follow.Extract(result)
+		if err != nil {
+			return err
+		}
+		engine.AddTask(tasks...)
+	}
+
+	if p.pagination != nil {
+		next, err := p.nextPage(result)
+		if err != nil {
+			return err
+		}
+		if next != nil {
+			engine.AddTask(next)
+		}
+	}
+
+	return nil
+}
+
+// finalURL returns the URL of a result after redirects.
+func finalURL(result *DownloadResult) string {
+	if result.URL != "" {
+		return result.URL
+	}
+	return result.Task.URL
+}
+
+func (p *RuleProcessor) matchURL(url string) bool {
+	if len(p.urls) == 0 {
+		return true
+	}
+	for _, re := range p.urls {
+		if re.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleScope is a part of a page from which fields are selected.
+type ruleScope struct {
+	result *DownloadResult
+	html   *goquery.Selection
+	json   *JSONValue
+	text   string
+}
+
+func (p *RuleProcessor) extractItems(result *DownloadResult) ([]Item, error) {
+	scopes, err := p.scopes(result)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	for _, scope := range scopes {
+		item := make(Item)
+		for _, field := range p.fields {
+			values, err := scope.selectValues(field.selector)
+			if err != nil {
+				return nil, fmt.Errorf("select field %s failed, reason: %v", field.Name, err)
+			}
+
+			value, err := field.convert(values)
+			if err != nil {
+				return nil, fmt.Errorf("convert field %s failed, reason: %v", field.Name, err)
+			}
+			item[field.Name] = value
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (p *RuleProcessor) scopes(result *DownloadResult) ([]*ruleScope, error) {
+	text, err := result.Text()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ruleScope{result: result, text: text}
+	if p.scope == nil {
+		return []*ruleScope{page}, nil
+	}
+
+	if p.scope.JSONPath != "" {
+		value, err := result.JSONPath(p.scope.JSONPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var scopes []*ruleScope
+		for _, element := range value.Array() {
+			element := element
+			scopes = append(scopes, &ruleScope{result: result, json: &element, text: element.Text()})
+		}
+		return scopes, nil
+	}
+
+	doc, err := result.HTML()
+	if err != nil {
+		return nil, err
+	}
+
+	var selection *goquery.Selection
+	if p.scope.CSS != "" {
+		selection = doc.Find(p.scope.CSS)
+	} else {
+		nodes, err := HTMLNodes(doc.Nodes).XPath(p.scope.XPath)
+		if err != nil {
+			return nil, err
+		}
+		selection = doc.FindNodes(nodes...)
+	}
+
+	var scopes []*ruleScope
+	selection.Each(func(_ int, s *goquery.Selection) {
+		html, _ := goquery.OuterHtml(s)
+		scopes = append(scopes, &ruleScope{result: result, html: s, text: html})
+	})
+	return scopes, nil
+}
+
+// selectValues returns every value selected by a selector in the scope.
+func (s *ruleScope) selectValues(selector *compiledSelector) ([]string, error) {
+	var values []string
+
+	switch {
+	case selector.CSS != "":
+		selection := s.html
+		if selection == nil {
+			doc, err := s.result.HTML()
+			if err != nil {
+				return nil, err
+			}
+			selection = doc.Selection
+		}
+		selection.Find(selector.CSS).Each(func(_ int, element *goquery.Selection) {
+			if selector.Attr != "" {
+				if value, exists := element.Attr(selector.Attr); exists {
+					values = append(values, value)
+				}
+			} else {
+				values = append(values, element.Text())
+			}
+		})
+
+	case selector.XPath != "":
+		var root HTMLNodes
+		if s.html != nil {
+			root = s.html.Nodes
+		} else {
+			doc, err := s.result.HTML()
+			if err != nil {
+				return nil, err
+			}
+			root = doc.Nodes
+		}
+		nodes, err := root.XPath(selector.XPath)
+		if err != nil {
+			return nil, err
+		}
+		for i := range nodes {
+			if selector.Attr != "" {
+				values = append(values, nodes[i:i+1].Attr(selector.Attr))
+			} else {
+				values = append(values, nodes[i:i+1].Text())
+			}
+		}
+
+	case selector.JSONPath != "":
+		var value JSONValue
+		var err error
+		if s.json != nil {
+			value, err = s.json.JSONPath(selector.JSONPath)
+		} else {
+			value, err = s.result.JSONPath(selector.JSONPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = value.Texts()
+
+	default:
+		values = []string{s.text}
+	}
+
+	if selector.regex == nil {
+		return values, nil
+	}
+
+	var matched []string
+	for _, value := range values {
+		for _, match := range selector.regex.FindAllStringSubmatch(value, -1) {
+			if len(match) > 1 {
+				matched = append(matched, match[1])
+			} else {
+				matched = append(matched, match[0])
+			}
+		}
+	}
+	return matched, nil
+}
+
+// convert converts selected values to the type of the field.
+func (f *compiledField) convert(values []string) (interface{}, error) {
+	var converted []interface{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+
+		var v interface{}
+		var err error
+		switch f.Type {
+		case "int":
+			v, err = strconv.ParseInt(strings.Replace(value, ",", "", -1), 10, 64)
+		case "float":
+			v, err = strconv.ParseFloat(strings.Replace(value, ",", "", -1), 64)
+		case "bool":
+			v, err = strconv.ParseBool(value)
+		case "time":
+			v, err = time.Parse(f.Layout, value)
+		default:
+			v = value
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		converted = append(converted, v)
+		if !f.Multiple {
+			break
+		}
+	}
+
+	if len(converted) == 0 {
+		if f.Required {
+			return nil, fmt.Errorf("field is required but nothing is selected")
+		}
+		return nil, nil
+	}
+
+	if f.Multiple {
+		return converted, nil
+	}
+	return converted[0], nil
+}
+
+func (p *RuleProcessor) nextPage(result *DownloadResult) (*Task, error) {
+	text, err := result.Text()
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := (&ruleScope{result: result, text: text}).selectValues(p.pagination)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 || strings.TrimSpace(values[0]) == "" {
+		return nil, nil
+	}
+
+	base, err := resultURL(result)
+	if err != nil {
+		return nil, err
+	}
+	next, err := base.Parse(strings.TrimSpace(values[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid next page url %s, reason: %v", values[0], err)
+	}
+
+	return &Task{
+		URL:           next.String(),
+		Method:        "GET",
+		ProcessorName: p.Name,
+	}, nil
+}
+
+func logItem(item Item, result *DownloadResult, engine *Engine) error {
+	log.Infof("Retrieved item %v from %s", item, result.Task.Name())
+	return nil
+}
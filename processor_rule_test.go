@@ -0,0 +1,239 @@
+package krawler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRuleProcessor(t *testing.T) {
+	tests := []struct {
+		definition RuleDefinition
+		field      string
+	}{
+		{RuleDefinition{Name: "page"}, ""},
+		{RuleDefinition{}, "name"},
+		{RuleDefinition{Name: "page", URLs: []string{"^/ok$", "("}}, "urls[1]"},
+		{RuleDefinition{Name: "page", Scope: RuleSelector{CSS: "li", XPath: "//li"}}, "scope"},
+		{RuleDefinition{Name: "page", Scope: RuleSelector{CSS: "li", Regex: "x"}}, "scope.regex"},
+		{RuleDefinition{Name: "page", Scope: RuleSelector{CSS: "li["}}, "scope.css"},
+		{RuleDefinition{Name: "page", Scope: RuleSelector{XPath: "//li["}}, "scope.xpath"},
+		{RuleDefinition{Name: "page", Scope: RuleSelector{JSONPath: "items"}}, "scope.jsonpath"},
+		{RuleDefinition{Name: "page", Fields: []FieldRule{{RuleSelector: RuleSelector{CSS: "h1"}}}}, "fields[0].name"},
+		{RuleDefinition{Name: "page", Fields: []FieldRule{{Name: "a", RuleSelector: RuleSelector{CSS: "h1"}}, {Name: "a", RuleSelector: RuleSelector{CSS: "h2"}}}}, "fields[1].name"},
+		{RuleDefinition{Name: "page", Fields: []FieldRule{{Name: "a"}}}, "fields[0]"},
+		{RuleDefinition{Name: "page", Fields: []FieldRule{{Name: "a", RuleSelector: RuleSelector{CSS: "h1"}, Type: "date"}}}, "fields[0].type"},
+		{RuleDefinition{Name: "page", Fields: []FieldRule{{Name: "a", RuleSelector: RuleSelector{CSS: "h1"}, Type: "time"}}}, "fields[0].layout"},
+		{RuleDefinition{Name: "page", Fields: []FieldRule{{Name: "a", RuleSelector: RuleSelector{Regex: "("}}}}, "fields[0].regex"},
+		{RuleDefinition{Name: "page", Fields: []FieldRule{{Name: "a", RuleSelector: RuleSelector{Regex: `id=(\d+)`}}}}, ""},
+		{RuleDefinition{Name: "page", Follow: []FollowRule{{Allow: []string{"x"}}}}, "follow[0].processor"},
+		{RuleDefinition{Name: "page", Follow: []FollowRule{{Processor: "page", Deny: []string{"("}}}}, "follow"},
+		{RuleDefinition{Name: "page", Pagination: RuleSelector{CSS: "a.next", JSONPath: "$.next"}}, "pagination"},
+	}
+
+	for i, test := range tests {
+		_, err := NewRuleProcessor(&test.definition, "test.yaml")
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("definition %d: expect no error, got %v", i, err)
+			}
+			continue
+		}
+
+		ruleErr, ok := err.(*RuleError)
+		if !ok {
+			t.Errorf("definition %d: expect a rule error at %s, got %v", i, test.field, err)
+			continue
+		}
+		if ruleErr.File != "test.yaml" || ruleErr.Field != test.field {
+			t.Errorf("definition %d: expect an error at test.yaml %s, got %v", i, test.field, ruleErr)
+		}
+	}
+}
+
+func TestLoadRuleProcessor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krawler-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		file    string
+		content string
+		field   string
+		reason  string
+	}{
+		{"ok.yaml", "name: page\nfields:\n  - name: title\n    css: h1\n    required: true\n", "", ""},
+		{"ok.yml", "name: page\nfollow:\n  - processor: page\n    skip_nofollow: true\n", "", ""},
+		{"ok.json", `{"name": "page", "fields": [{"name": "title", "css": "h1", "multiple": true}]}`, "", ""},
+		{"typo.yaml", "name: page\nfeilds: []\n", "-", "feilds"},
+		{"invalid.yaml", "name: page\nfields:\n  - name: title\n    css: h1\n    type: date\n", "fields[0].type", "date"},
+		{"syntax.json", "{\n  \"name\": \"page\",\n  \"fields\": [}\n}", "line 3", "invalid character"},
+		{"typo.json", `{"name": "page", "fields": [{"name": "title", "css": "h1", "tpye": "int"}]}`, "fields[0].tpye", "unknown field"},
+		{"type.json", `{"name": "page", "fields": [{"name": "title", "css": "h1", "multiple": "yes"}]}`, "fields[0].multiple", "expects a boolean, got a string"},
+		{"number.json", `{"name": "page", "urls": ["^/a", 1]}`, "urls[1]", "expects a string, got a number"},
+		{"list.json", `{"name": "page", "follow": {"processor": "page"}}`, "follow", "expects a list, got an object"},
+		{"root.json", `["page"]`, "-", "expects an object, got a list"},
+		{"embedded.json", `{"name": "page", "pagination": {"CSS": "a.next", "attr": "href"}}`, "", ""},
+		{"invalid.json", `{"name": "page", "urls": ["("]}`, "urls[0]", "invalid pattern"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.file)
+		if err := ioutil.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		processor, err := LoadRuleProcessor(path)
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%s: expect no error, got %v", test.file, err)
+			} else if processor.Name != "page" {
+				t.Errorf("%s: expect processor page, got %s", test.file, processor.Name)
+			}
+			continue
+		}
+
+		ruleErr, ok := err.(*RuleError)
+		if !ok {
+			t.Errorf("%s: expect a rule error, got %v", test.file, err)
+			continue
+		}
+		if ruleErr.File != path || ruleErr.Field != test.field || !strings.Contains(ruleErr.Reason, test.reason) {
+			t.Errorf("%s: expect an error at %s containing %q, got %v", test.file, test.field, test.reason, ruleErr)
+		}
+	}
+
+	if _, err := LoadRuleProcessor(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("expect a missing file to fail")
+	}
+}
+
+func TestRuleProcessorExtract(t *testing.T) {
+	page := `<html><body>
+<div class="product"><h2>Lamp</h2><span class="price">1,299.50</span><span class="stock">true</span>
+	<time>2020-01-02</time><a href="/p/1?ref=list">more</a><i>new</i><i>sale</i></div>
+<div class="product"><h2>Desk</h2><span class="price">80</span><span class="stock">false</span>
+	<time>2020-02-03</time><a href="/p/2">more</a></div>
+<a class="next" href="?page=2">next</a>
+</body></html>`
+
+	definition := &RuleDefinition{
+		Name:  "products",
+		URLs:  []string{`/list`},
+		Scope: RuleSelector{CSS: ".product"},
+		Fields: []FieldRule{
+			{Name: "name", RuleSelector: RuleSelector{XPath: ".//h2"}},
+			{Name: "price", Type: "float", RuleSelector: RuleSelector{CSS: ".price"}},
+			{Name: "stock", Type: "bool", RuleSelector: RuleSelector{CSS: ".stock"}},
+			{Name: "date", Type: "time", Layout: "2006-01-02", RuleSelector: RuleSelector{CSS: "time"}},
+			{Name: "id", Type: "int", RuleSelector: RuleSelector{CSS: "a", Attr: "href", Regex: `/p/(\d+)`}},
+			{Name: "tags", Multiple: true, RuleSelector: RuleSelector{CSS: "i"}},
+		},
+		Pagination: RuleSelector{CSS: "a.next", Attr: "href"},
+	}
+	processor, err := NewRuleProcessor(definition, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var items []Item
+	processor.OnItem(func(item Item, _ *DownloadResult, _ *Engine) error {
+		items = append(items, item)
+		return nil
+	})
+
+	e := new(Engine)
+	e.Initialize(&Config{})
+	e.InstallQueue(NewLocalQueue())
+	e.InstallProcessor(processor.Process, processor.Name)
+
+	// the page is matched by its final URL
+	result := &DownloadResult{
+		URL:     "http://example.com/list?page=1",
+		Content: []byte(page),
+		Task:    &Task{URL: "http://example.com/old", Method: "GET", ProcessorName: "products"},
+	}
+	if err := processor.Process(result, e); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Item{
+		{
+			"name": "Lamp", "price": 1299.5, "stock": true, "date": time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+			"id": int64(1), "tags": []interface{}{"new", "sale"},
+		},
+		{
+			"name": "Desk", "price": 80.0, "stock": false, "date": time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC),
+			"id": int64(2), "tags": nil,
+		},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("expect items %v, got %v", expected, items)
+	}
+
+	next, _ := e.queue.Pop()
+	if next == nil || next.URL != "http://example.com/list?page=2" || next.ProcessorName != "products" {
+		t.Errorf("expect the next page to be added, got %v", next)
+	}
+
+	items = nil
+	result = &DownloadResult{
+		URL:     "http://example.com/about",
+		Content: []byte(page),
+		Task:    &Task{URL: "http://example.com/list", Method: "GET", ProcessorName: "products"},
+	}
+	if err := processor.Process(result, e); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expect no items from a page redirected out of urls, got %v", items)
+	}
+}
+
+func TestRuleProcessorJSONScope(t *testing.T) {
+	definition := &RuleDefinition{
+		Name:  "api",
+		Scope: RuleSelector{JSONPath: "$.items"},
+		Fields: []FieldRule{
+			{Name: "id", RuleSelector: RuleSelector{JSONPath: "$.id"}},
+			{Name: "score", Type: "int", RuleSelector: RuleSelector{JSONPath: "$.score"}},
+			{Name: "title", Required: true, RuleSelector: RuleSelector{JSONPath: "$.title"}},
+		},
+	}
+	processor, err := NewRuleProcessor(definition, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var items []Item
+	processor.OnItem(func(item Item, _ *DownloadResult, _ *Engine) error {
+		items = append(items, item)
+		return nil
+	})
+
+	result := &DownloadResult{
+		Content: []byte(`{"items": [{"id": 1234567890123456789, "score": 7, "title": "a"}]}`),
+		Task:    &Task{URL: "http://example.com/api", Method: "GET"},
+	}
+	if err := processor.Process(result, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Item{{"id": "1234567890123456789", "score": int64(7), "title": "a"}}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("expect items %v, got %v", expected, items)
+	}
+
+	result = &DownloadResult{
+		Content: []byte(`{"items": [{"id": 1, "score": 7}]}`),
+		Task:    &Task{URL: "http://example.com/api", Method: "GET"},
+	}
+	if err := processor.Process(result, nil); err == nil || !strings.Contains(err.Error(), "title") {
+		t.Errorf("expect the missing required field to fail, got %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+package krawler
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Proxy selection strategies of ProxyPool
+const (
+	ProxyStrategyRoundRobin = "round-robin"
+	ProxyStrategyRandom     = "random"
+	ProxyStrategySticky     = "sticky"
+)
+
+var (
+	// ErrNoProxyAvailable indicates every proxy in the pool is unhealthy
+	ErrNoProxyAvailable = errors.New("no healthy proxy is available")
+
+	// ErrProxyBanned indicates the response looks like the proxy is banned by the target
+	ErrProxyBanned = errors.New("proxy is banned by the target")
+)
+
+// ProxyPool holds a series of proxies and picks one of them for every request.
+// A proxy becomes unhealthy after too many consecutive connection errors or a ban
+// signal, and is tested again after it cools down.
+type ProxyPool struct {
+	mutex    sync.Mutex
+	proxies  []*pooledProxy
+	strategy string
+	next     int
+	sticky   map[string]*pooledProxy
+
+	maxFailures     int
+	cooldown        time.Duration
+	banStatusCodes  map[int]bool
+	banBodyPatterns []*regexp.Regexp
+	testURL         string
+	tlsConfig       *tls.Config
+}
+
+type pooledProxy struct {
+	url           *url.URL
+	id            string
+	failures      int
+	healthy       bool
+	unhealthyTill time.Time
+	testing       bool
+	testClient    *http.Client
+}
+
+// NewProxyPool creates a proxy pool from config.
+func NewProxyPool(config ProxyPoolConfig) (*ProxyPool, error) {
+	pool := &ProxyPool{
+		strategy:       config.Strategy,
+		sticky:         make(map[string]*pooledProxy),
+		maxFailures:    config.MaxFailures,
+		cooldown:       config.Cooldown,
+		banStatusCodes: make(map[int]bool),
+		testURL:        config.TestURL,
+	}
+
+	switch pool.strategy {
+	case "":
+		pool.strategy = ProxyStrategyRoundRobin
+	case ProxyStrategyRoundRobin, ProxyStrategyRandom, ProxyStrategySticky:
+	default:
+		return nil, fmt.Errorf("unknown proxy strategy %s", config.Strategy)
+	}
+	if pool.maxFailures <= 0 {
+		pool.maxFailures = 1
+	}
+
+	rawURLs := config.Proxies
+	if config.File != "" {
+		fromFile, err := readProxyFile(config.File)
+		if err != nil {
+			return nil, err
+		}
+		rawURLs = append(rawURLs, fromFile...)
+	}
+	if len(rawURLs) == 0 {
+		return nil, fmt.Errorf("no proxy is configured")
+	}
+
+	for _, rawURL := range rawURLs {
+		proxyURL, err := url.Parse(rawURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %s", rawURL)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported scheme of proxy %s", redactURL(proxyURL))
+		}
+		pool.proxies = append(pool.proxies, &pooledProxy{url: proxyURL, id: redactURL(proxyURL), healthy: true})
+	}
+
+	for _, code := range config.BanStatusCodes {
+		pool.banStatusCodes[code] = true
+	}
+	for _, pattern := range config.BanBodyPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ban pattern %q, reason: %v", pattern, err)
+		}
+		pool.banBodyPatterns = append(pool.banBodyPatterns, re)
+	}
+
+	return pool, nil
+}
+
+// readProxyFile reads proxies from a file, one per line. Empty lines and lines
+// starting with # are ignored.
+func readProxyFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open proxy file %s failed, reason: %v", path, err)
+	}
+	defer file.Close()
+
+	var proxies []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			proxies = append(proxies, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read proxy file %s failed, reason: %v", path, err)
+	}
+
+	return proxies, nil
+}
+
+// Pick chooses a healthy proxy for a request to host. The proxy named by avoid,
+// usually the one used by the last failed attempt, is only chosen if no other
+// proxy is healthy. Proxies are named by their URLs with passwords redacted, as
+// in Task.Meta.Proxy.
+func (p *ProxyPool) Pick(host string, avoid string) (*url.URL, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.recover()
+
+	var candidates []*pooledProxy
+	var avoided *pooledProxy
+	for _, proxy := range p.proxies {
+		if !proxy.healthy {
+			continue
+		}
+		if proxy.id == avoid {
+			avoided = proxy
+			continue
+		}
+		candidates = append(candidates, proxy)
+	}
+	if len(candidates) == 0 {
+		if avoided == nil {
+			return nil, ErrNoProxyAvailable
+		}
+		candidates = append(candidates, avoided)
+	}
+
+	var chosen *pooledProxy
+	switch p.strategy {
+	case ProxyStrategyRandom:
+		chosen = candidates[rand.Intn(len(candidates))]
+	case ProxyStrategySticky:
+		chosen = p.sticky[host]
+		if chosen == nil || !chosen.healthy || (chosen == avoided && len(candidates) > 1) {
+			chosen = candidates[p.next%len(candidates)]
+			p.next++
+			p.sticky[host] = chosen
+		}
+	default:
+		chosen = candidates[p.next%len(candidates)]
+		p.next++
+	}
+
+	return chosen.url, nil
+}
+
+// recover brings back proxies that have cooled down, testing them first if a test
+// URL is configured.
+func (p *ProxyPool) recover() {
+	now := time.Now()
+	for _, proxy := range p.proxies {
+		if proxy.healthy || proxy.testing || now.Before(proxy.unhealthyTill) {
+			continue
+		}
+
+		if p.testURL == "" {
+			proxy.healthy = true
+			proxy.failures = 0
+			log.Infof("Proxy %s has cooled down and is back to the pool", proxy.id)
+			continue
+		}
+
+		proxy.testing = true
+		if proxy.testClient == nil {
+			proxy.testClient = p.newTestClient(proxy.url)
+		}
+		go p.test(proxy)
+	}
+}
+
+// setTLSConfig makes tests of proxies trust the same certificates as the
+// downloader.
+func (p *ProxyPool) setTLSConfig(config *tls.Config) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if config != nil {
+		config = config.Clone()
+		// the test client only speaks HTTP/1.1
+		config.NextProtos = nil
+	}
+	p.tlsConfig = config
+}
+
+// newTestClient creates the client testing a proxy, which is kept with the proxy
+// for later tests.
+func (p *ProxyPool) newTestClient(proxyURL *url.URL) *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: p.tlsConfig,
+		},
+	}
+}
+
+func (p *ProxyPool) test(proxy *pooledProxy) {
+	healthy := false
+	response, err := proxy.testClient.Get(p.testURL)
+	if err == nil {
+		response.Body.Close()
+		healthy = response.StatusCode < 400 && !p.banStatusCodes[response.StatusCode]
+	}
+	// the next test is long after, so connections are not kept
+	proxy.testClient.Transport.(*http.Transport).CloseIdleConnections()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	proxy.testing = false
+	if healthy {
+		proxy.healthy = true
+		proxy.failures = 0
+		log.Infof("Proxy %s passes the test and is back to the pool", proxy.id)
+	} else {
+		proxy.unhealthyTill = time.Now().Add(p.cooldown)
+		log.Warnf("Proxy %s fails the test and keeps cooling down", proxy.id)
+	}
+}
+
+func (p *ProxyPool) find(proxyURL *url.URL) *pooledProxy {
+	for _, proxy := range p.proxies {
+		if proxy.url == proxyURL {
+			return proxy
+		}
+	}
+	return nil
+}
+
+// ReportSuccess resets the failure count of a proxy.
+func (p *ProxyPool) ReportSuccess(proxyURL *url.URL) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if proxy := p.find(proxyURL); proxy != nil {
+		proxy.failures = 0
+	}
+}
+
+// ReportFailure records an error connecting through a proxy and marks the proxy as
+// unhealthy if it fails too many times in a row. Errors of the target site should
+// not be reported.
+func (p *ProxyPool) ReportFailure(proxyURL *url.URL) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if proxy := p.find(proxyURL); proxy != nil {
+		proxy.failures++
+		if proxy.failures >= p.maxFailures {
+			p.markUnhealthy(proxy, "it fails too many times")
+		}
+	}
+}
+
+// ReportBan marks a proxy as unhealthy because the target bans it.
+func (p *ProxyPool) ReportBan(proxyURL *url.URL) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if proxy := p.find(proxyURL); proxy != nil {
+		p.markUnhealthy(proxy, "it is banned")
+	}
+}
+
+func (p *ProxyPool) markUnhealthy(proxy *pooledProxy, reason string) {
+	if proxy.healthy {
+		log.Warnf("Proxy %s is removed from the pool for %v because %s", proxy.id, p.cooldown, reason)
+	}
+	proxy.healthy = false
+	proxy.unhealthyTill = time.Now().Add(p.cooldown)
+}
+
+// banSniffLength is the number of leading bytes of a streamed body matched against
+// the ban patterns.
+const banSniffLength = 64 << 10
+
+// IsBanned checks a download result against the configured ban signals. Only the
+// head of a body streamed into a file is checked.
+func (p *ProxyPool) IsBanned(result *DownloadResult) bool {
+	if p.banStatusCodes[result.StatusCode] {
+		return true
+	}
+	if len(p.banBodyPatterns) == 0 {
+		return false
+	}
+
+	content := result.Content
+	if result.BodyPath != "" {
+		head, err := headOf(result, banSniffLength)
+		if err != nil {
+			log.Errorf("Fail to read body of %s to detect bans, reason: %v", result.Task.Name(), err)
+			return false
+		}
+		content = head
+	}
+	for _, re := range p.banBodyPatterns {
+		if re.Match(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL returns the URL with its password replaced by "xxxxx", so that proxy
+// credentials are not logged.
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	if _, hasPassword := u.User.Password(); !hasPassword {
+		return u.String()
+	}
+
+	redacted := *u
+	redacted.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return redacted.String()
+}
@@ -2,6 +2,9 @@ package krawler
 
 import (
 	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // EnqueuePosition indicates where a task will be inserted
@@ -17,6 +20,9 @@ const (
 var (
 	// ErrQueueTaskDuplicated indicates that a specific task has already been added to the queue
 	ErrQueueTaskDuplicated = fmt.Errorf("task is duplicated")
+
+	// ErrQueueForgetNotSupported indicates that the queue cannot forget tasks
+	ErrQueueForgetNotSupported = fmt.Errorf("queue cannot forget tasks")
 )
 
 // Queue defines a queue interface
@@ -35,3 +41,96 @@ type Queue interface {
 	// Len returns the amount of tasks in the queue.
 	Len() (int64, error)
 }
+
+// queueOptions are the options shared by queues.
+type queueOptions struct {
+	normalizer   *URLNormalizer
+	deduplicator Deduplicator
+	keyFunc      func(task *Task) string
+}
+
+// QueueOption customizes a queue.
+type QueueOption func(*queueOptions)
+
+// WithURLNormalizer makes the queue normalize the URL of a task before checking
+// its duplication. The task itself keeps its URL.
+func WithURLNormalizer(normalizer *URLNormalizer) QueueOption {
+	return func(o *queueOptions) {
+		o.normalizer = normalizer
+	}
+}
+
+// WithDeduplicator makes the queue check duplication of tasks with deduplicator
+// instead of the default one of the queue. The deduplicator is closed with the
+// queue if it can be closed.
+func WithDeduplicator(deduplicator Deduplicator) QueueOption {
+	return func(o *queueOptions) {
+		o.deduplicator = deduplicator
+	}
+}
+
+// closeDeduplicator closes the deduplicator given by WithDeduplicator if it can be
+// closed.
+func (o *queueOptions) closeDeduplicator() {
+	if closer, ok := o.deduplicator.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Errorf("Fail to close deduplicator, reason: %v", err)
+		}
+	}
+}
+
+func newQueueOptions(options []QueueOption) queueOptions {
+	o := queueOptions{}
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// visitMarker is implemented by queues that can check duplication of a task
+// without enqueuing it, which Config.Request.DeduplicateFinalURL relies on.
+type visitMarker interface {
+	// dedupKey returns the key of a task in the duplication check.
+	dedupKey(item *Task) string
+
+	// MarkVisited marks a task as visited and reports whether it has been visited
+	// before, using the same rule as the duplication check of Enqueue.
+	MarkVisited(item *Task) (bool, error)
+}
+
+// taskForgetter is implemented by queues that can remove a task from the
+// duplication check, which Engine.ForgetTask relies on.
+type taskForgetter interface {
+	// Forget removes a task from the duplication check, so that it can be enqueued
+	// again.
+	Forget(item *Task) error
+}
+
+// dedupKeyQueue is implemented by queues taking a function that computes the key
+// of a task in the duplication check. The engine sets it to apply the DedupKey
+// functions of processors.
+type dedupKeyQueue interface {
+	setDedupKeyFunc(keyFunc func(task *Task) string)
+}
+
+// dedupKey returns the key of a task in the duplication check, which is the key
+// given by the key function if not empty, otherwise its hash code after
+// normalizing the URL.
+func (o *queueOptions) dedupKey(task *Task) string {
+	if o.keyFunc != nil {
+		if key := o.keyFunc(task); key != "" {
+			return key
+		}
+	}
+	if o.normalizer == nil {
+		return task.HashCode()
+	}
+
+	normalized, err := o.normalizer.Normalize(task.URL)
+	if err != nil {
+		return task.HashCode()
+	}
+	normalizedTask := *task
+	normalizedTask.URL = normalized
+	return normalizedTask.HashCode()
+}
@@ -5,25 +5,32 @@ import (
 	"sync"
 )
 
-// LocalQueue holds a series of tasks. Follow FIFO rule.
+// LocalQueue holds a series of tasks. Follow FIFO rule. Duplication is checked
+// with a MemoryDeduplicator unless another deduplicator is given.
 type LocalQueue struct {
 	mutex   *sync.Mutex
 	tasks   *list.List
-	visited map[string]bool
+	visited Deduplicator
+	options queueOptions
 }
 
 // NewLocalQueue creates a queue which basic storage is a double linked list
-func NewLocalQueue() *LocalQueue {
+func NewLocalQueue(options ...QueueOption) *LocalQueue {
 	queue := &LocalQueue{
 		tasks:   list.New(),
-		visited: make(map[string]bool),
 		mutex:   &sync.Mutex{},
+		options: newQueueOptions(options),
+	}
+	queue.visited = queue.options.deduplicator
+	if queue.visited == nil {
+		queue.visited = NewMemoryDeduplicator()
 	}
 	return queue
 }
 
 // Transfer the tasks in the list into persisted storage
 func (q *LocalQueue) Shutdown() {
+	q.options.closeDeduplicator()
 }
 
 // Enqueue add a task into the queue
@@ -31,8 +38,14 @@ func (q *LocalQueue) Enqueue(task *Task, allowDuplication bool, position Enqueue
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
-	if !allowDuplication && !q.checkDuplication(task) {
-		return ErrQueueTaskDuplicated
+	if !allowDuplication {
+		visited, err := q.visited.Visit(q.options.dedupKey(task), task.RecrawlInterval)
+		if err != nil {
+			return err
+		}
+		if visited {
+			return ErrQueueTaskDuplicated
+		}
 	}
 
 	switch position {
@@ -45,6 +58,24 @@ func (q *LocalQueue) Enqueue(task *Task, allowDuplication bool, position Enqueue
 	return nil
 }
 
+func (q *LocalQueue) dedupKey(task *Task) string {
+	return q.options.dedupKey(task)
+}
+
+// MarkVisited marks a task as visited and reports whether it has been visited before
+func (q *LocalQueue) MarkVisited(task *Task) (bool, error) {
+	return q.visited.Visit(q.options.dedupKey(task), task.RecrawlInterval)
+}
+
+// Forget removes a task from the duplication check
+func (q *LocalQueue) Forget(task *Task) error {
+	return q.visited.Forget(q.options.dedupKey(task))
+}
+
+func (q *LocalQueue) setDedupKeyFunc(keyFunc func(task *Task) string) {
+	q.options.keyFunc = keyFunc
+}
+
 // Pop returns a task in the front most and remove it from the queue
 func (q *LocalQueue) Pop() (*Task, error) {
 	q.mutex.Lock()
@@ -61,12 +92,3 @@ func (q *LocalQueue) Pop() (*Task, error) {
 func (q *LocalQueue) Len() (int64, error) {
 	return int64(q.tasks.Len()), nil
 }
-
-func (q *LocalQueue) checkDuplication(task *Task) bool {
-	hashCode := task.HashCode()
-	if visited := q.visited[hashCode]; visited {
-		return false
-	}
-	q.visited[hashCode] = true
-	return true
-}
@@ -8,14 +8,18 @@ import (
 )
 
 // RedisQueue is a queue that store the task in redis. Follow FIFO rule.
+// Duplication is checked with a RedisSetDeduplicator without TTL sharing the
+// connection of the queue, unless another deduplicator is given.
 type RedisQueue struct {
 	id    string
 	redis *redis.Client
 
-	redisKeyCounter           string
-	redisKeyItemPrefix        string
-	redisKeyQueue             string
-	redisKeyDuplicationPrefix string
+	redisKeyCounter    string
+	redisKeyItemPrefix string
+	redisKeyQueue      string
+
+	visited Deduplicator
+	options queueOptions
 }
 
 // redisScriptPush implements a lua script to push a task into the queue.
@@ -23,15 +27,13 @@ type RedisQueue struct {
 // ARGV = enqueuePosition, task
 var redisScriptPush = redis.NewScript(fmt.Sprintf(`
 local id = redis.call('INCR', KEYS[1])
-redis.call('SET', KEYS[3] .. id, ARGV[1])
+redis.call('SET', KEYS[3] .. id, ARGV[2])
 
 if ARGV[1] == '%d' then
-	redis.call('LPUSH', ARGV[2])
-else if ARGV[1] == '%d' then
-	redis.call('RPUSH', ARGV[2])
+	redis.call('LPUSH', KEYS[2], id)
 else
-	redis.call('RPUSH', ARGV[2])
-end`, EnqueuePositionHead, EnqueuePositionTail))
+	redis.call('RPUSH', KEYS[2], id)
+end`, EnqueuePositionHead))
 
 // redisScriptPop implements a lua script to pop a task from the queue.
 // KEYS = queueKey, taskKeyPrefix
@@ -48,15 +50,20 @@ redis.call('DEL', taskId)
 return value`)
 
 // NewRedisQueue creates a redis queue
-func NewRedisQueue(id string, redisOptions *redis.Options) *RedisQueue {
+func NewRedisQueue(id string, redisOptions *redis.Options, options ...QueueOption) *RedisQueue {
 	queue := &RedisQueue{
 		id:    id,
 		redis: redis.NewClient(redisOptions),
 
-		redisKeyCounter:           fmt.Sprintf("{krawler:%s}:counter", id),
-		redisKeyQueue:             fmt.Sprintf("{krawler:%s}:queue", id),
-		redisKeyDuplicationPrefix: fmt.Sprintf("{krawler:%s}:dup:", id),
-		redisKeyItemPrefix:        fmt.Sprintf("{krawler:%s}:task:", id),
+		redisKeyCounter:    fmt.Sprintf("{krawler:%s}:counter", id),
+		redisKeyQueue:      fmt.Sprintf("{krawler:%s}:queue", id),
+		redisKeyItemPrefix: fmt.Sprintf("{krawler:%s}:task:", id),
+
+		options: newQueueOptions(options),
+	}
+	queue.visited = queue.options.deduplicator
+	if queue.visited == nil {
+		queue.visited = newRedisSetDeduplicator(queue.redis, id, 0)
 	}
 
 	return queue
@@ -64,6 +71,8 @@ func NewRedisQueue(id string, redisOptions *redis.Options) *RedisQueue {
 
 // Transfer the tasks in the list into persisted storage
 func (q *RedisQueue) Shutdown() {
+	q.options.closeDeduplicator()
+
 	err := q.redis.Close()
 	if err != nil {
 		log.Errorf("Fail to close redis connection, reason: %v", err)
@@ -73,15 +82,12 @@ func (q *RedisQueue) Shutdown() {
 // Enqueue add a task into the queue
 func (q *RedisQueue) Enqueue(task *Task, allowDuplication bool, position EnqueuePosition) error {
 	if !allowDuplication {
-		hashCode := task.HashCode()
-		key := q.redisKeyDuplicationPrefix + hashCode
-		ok, err := q.redis.SetNX(key, q.id, 0).Result()
-
+		visited, err := q.MarkVisited(task)
 		if err != nil {
-			return fmt.Errorf("failed to check duplication for %s, reason: %v", hashCode, err)
+			return err
 		}
 
-		if !ok {
+		if visited {
 			return ErrQueueTaskDuplicated
 		}
 	}
@@ -91,23 +97,45 @@ func (q *RedisQueue) Enqueue(task *Task, allowDuplication bool, position Enqueue
 		return fmt.Errorf("fail to marshal a task, reason: %v", err)
 	}
 
-	_, err = redisScriptPush.Run(q.redis, []string{q.redisKeyCounter, q.redisKeyQueue, q.redisKeyItemPrefix}, position, taskInBytes).Result()
-	if err != redis.Nil {
+	_, err = redisScriptPush.Run(q.redis, []string{q.redisKeyCounter, q.redisKeyQueue, q.redisKeyItemPrefix}, int32(position), taskInBytes).Result()
+	if err != nil && err != redis.Nil {
 		return fmt.Errorf("fail to enqueue a task, reason: %v", err)
 	}
 
 	return nil
 }
 
+func (q *RedisQueue) dedupKey(task *Task) string {
+	return q.options.dedupKey(task)
+}
+
+// MarkVisited marks a task as visited and reports whether it has been visited before
+func (q *RedisQueue) MarkVisited(task *Task) (bool, error) {
+	return q.visited.Visit(q.options.dedupKey(task), task.RecrawlInterval)
+}
+
+// Forget removes a task from the duplication check
+func (q *RedisQueue) Forget(task *Task) error {
+	return q.visited.Forget(q.options.dedupKey(task))
+}
+
+func (q *RedisQueue) setDedupKeyFunc(keyFunc func(task *Task) string) {
+	q.options.keyFunc = keyFunc
+}
+
 // Pop returns a task in the front most and remove it from the queue
 func (q *RedisQueue) Pop() (*Task, error) {
 	rawTask, err := redisScriptPop.Run(q.redis, []string{q.redisKeyQueue, q.redisKeyItemPrefix}).Result()
-	if err != redis.Nil {
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
 		return nil, fmt.Errorf("fail to pop a task from the queue, reason: %v", err)
 	}
 
 	task := new(Task)
-	err = json.Unmarshal([]byte(rawTask.(string)), task)
+	if err := json.Unmarshal([]byte(rawTask.(string)), task); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal a task, reason: %v", err)
+	}
 
 	return task, nil
 }
@@ -0,0 +1,364 @@
+package krawler
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	json "github.com/json-iterator/go"
+	"github.com/oliveagle/jsonpath"
+	"golang.org/x/net/html"
+)
+
+// parsedContent caches documents parsed from the content of a download result.
+type parsedContent struct {
+	html    *goquery.Document
+	htmlErr error
+	xml     *xmlquery.Node
+	xmlErr  error
+	json    interface{}
+	jsonErr error
+	text    string
+	textErr error
+
+	htmlParsed  bool
+	xmlParsed   bool
+	jsonParsed  bool
+	textDecoded bool
+}
+
+func (r *DownloadResult) parsedContent() *parsedContent {
+	if r.parsed == nil {
+		r.parsed = &parsedContent{}
+	}
+	return r.parsed
+}
+
+// readBody opens the body and passes it to read. The body is decoded into UTF-8
+// if decode is true.
+func (r *DownloadResult) readBody(decode bool, read func(io.Reader) error) error {
+	open := r.Body
+	if decode {
+		open = r.textReader
+	}
+
+	body, err := open()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return read(body)
+}
+
+// HTML parses the content as an HTML document after decoding it into UTF-8. The
+// document is parsed once and shared by all the HTML helpers of the result.
+func (r *DownloadResult) HTML() (*goquery.Document, error) {
+	p := r.parsedContent()
+	if !p.htmlParsed {
+		p.htmlParsed = true
+		p.htmlErr = r.readBody(true, func(body io.Reader) (err error) {
+			p.html, err = goquery.NewDocumentFromReader(body)
+			return
+		})
+		if p.htmlErr != nil {
+			p.htmlErr = fmt.Errorf("parse html failed, reason: %v", p.htmlErr)
+		}
+	}
+	return p.html, p.htmlErr
+}
+
+// Find selects elements of the HTML document with a CSS selector.
+func (r *DownloadResult) Find(selector string) (*goquery.Selection, error) {
+	doc, err := r.HTML()
+	if err != nil {
+		return nil, err
+	}
+	return doc.Find(selector), nil
+}
+
+// XPath selects nodes of the HTML document with an XPath expression.
+func (r *DownloadResult) XPath(expr string) (HTMLNodes, error) {
+	doc, err := r.HTML()
+	if err != nil {
+		return nil, err
+	}
+	return HTMLNodes(doc.Nodes).XPath(expr)
+}
+
+// XML parses the content as an XML document. The charset is decided by the XML
+// declaration.
+func (r *DownloadResult) XML() (*xmlquery.Node, error) {
+	p := r.parsedContent()
+	if !p.xmlParsed {
+		p.xmlParsed = true
+		p.xmlErr = r.readBody(false, func(body io.Reader) (err error) {
+			p.xml, err = xmlquery.Parse(body)
+			return
+		})
+		if p.xmlErr != nil {
+			p.xmlErr = fmt.Errorf("parse xml failed, reason: %v", p.xmlErr)
+		}
+	}
+	return p.xml, p.xmlErr
+}
+
+// XMLPath selects nodes of the XML document with an XPath expression.
+func (r *DownloadResult) XMLPath(expr string) (XMLNodes, error) {
+	doc, err := r.XML()
+	if err != nil {
+		return nil, err
+	}
+	return XMLNodes{doc}.XPath(expr)
+}
+
+// JSON parses the content as a JSON document. Numbers are decoded into float64,
+// except integers too large to be exact in float64, which are kept as json.Number.
+func (r *DownloadResult) JSON() (JSONValue, error) {
+	p := r.parsedContent()
+	if !p.jsonParsed {
+		p.jsonParsed = true
+		p.jsonErr = r.readBody(true, func(body io.Reader) error {
+			decoder := json.NewDecoder(body)
+			decoder.UseNumber()
+			if err := decoder.Decode(&p.json); err != nil {
+				return err
+			}
+			p.json = exactNumbers(p.json)
+			return nil
+		})
+		if p.jsonErr != nil {
+			p.jsonErr = fmt.Errorf("parse json failed, reason: %v", p.jsonErr)
+		}
+	}
+	return JSONValue{p.json}, p.jsonErr
+}
+
+// maxExactInteger is the largest integer that float64 holds exactly.
+const maxExactInteger = 1 << 53
+
+// exactNumbers turns the numbers of a value decoded with UseNumber into float64,
+// unless they are integers that would lose precision. JSONPath filters only
+// compare float64 numbers.
+func exactNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, element := range value {
+			value[key] = exactNumbers(element)
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = exactNumbers(element)
+		}
+	case stdjson.Number:
+		if !strings.ContainsAny(string(value), ".eE") {
+			if n, err := value.Int64(); err != nil || n > maxExactInteger || n < -maxExactInteger {
+				return value
+			}
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// JSONPath selects values of the JSON document with a JSONPath expression.
+func (r *DownloadResult) JSONPath(expr string) (JSONValue, error) {
+	doc, err := r.JSON()
+	if err != nil {
+		return JSONValue{}, err
+	}
+	return doc.JSONPath(expr)
+}
+
+// HTMLNodes is a list of nodes selected from an HTML document.
+type HTMLNodes []*html.Node
+
+// XPath selects nodes under the nodes with an XPath expression.
+func (nodes HTMLNodes) XPath(expr string) (HTMLNodes, error) {
+	compiled, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xpath %q, reason: %v", expr, err)
+	}
+
+	var selected HTMLNodes
+	for _, node := range nodes {
+		iter := compiled.Select(htmlquery.CreateXPathNavigator(node))
+		for iter.MoveNext() {
+			selected = append(selected, iter.Current().(*htmlquery.NodeNavigator).Current())
+		}
+	}
+	return selected, nil
+}
+
+// Text returns the inner text of the first node.
+func (nodes HTMLNodes) Text() string {
+	if len(nodes) == 0 {
+		return ""
+	}
+	return htmlquery.InnerText(nodes[0])
+}
+
+// Texts returns the inner text of every node.
+func (nodes HTMLNodes) Texts() []string {
+	texts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		texts = append(texts, htmlquery.InnerText(node))
+	}
+	return texts
+}
+
+// Attr returns the value of an attribute of the first node.
+func (nodes HTMLNodes) Attr(name string) string {
+	if len(nodes) == 0 {
+		return ""
+	}
+	return htmlquery.SelectAttr(nodes[0], name)
+}
+
+// HTML returns the outer HTML of the first node.
+func (nodes HTMLNodes) HTML() string {
+	if len(nodes) == 0 {
+		return ""
+	}
+	return htmlquery.OutputHTML(nodes[0], true)
+}
+
+// XMLNodes is a list of nodes selected from an XML document.
+type XMLNodes []*xmlquery.Node
+
+// XPath selects nodes under the nodes with an XPath expression.
+func (nodes XMLNodes) XPath(expr string) (XMLNodes, error) {
+	compiled, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xpath %q, reason: %v", expr, err)
+	}
+
+	var selected XMLNodes
+	for _, node := range nodes {
+		iter := compiled.Select(xmlquery.CreateXPathNavigator(node))
+		for iter.MoveNext() {
+			selected = append(selected, iter.Current().(*xmlquery.NodeNavigator).Current())
+		}
+	}
+	return selected, nil
+}
+
+// Text returns the inner text of the first node.
+func (nodes XMLNodes) Text() string {
+	if len(nodes) == 0 {
+		return ""
+	}
+	return nodes[0].InnerText()
+}
+
+// Texts returns the inner text of every node.
+func (nodes XMLNodes) Texts() []string {
+	texts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		texts = append(texts, node.InnerText())
+	}
+	return texts
+}
+
+// Attr returns the value of an attribute of the first node.
+func (nodes XMLNodes) Attr(name string) string {
+	if len(nodes) == 0 {
+		return ""
+	}
+	return nodes[0].SelectAttr(name)
+}
+
+// XML returns the outer XML of the first node.
+func (nodes XMLNodes) XML() string {
+	if len(nodes) == 0 {
+		return ""
+	}
+	return nodes[0].OutputXML(true)
+}
+
+// JSONValue wraps a value decoded from a JSON document.
+type JSONValue struct {
+	value interface{}
+}
+
+// JSONPath selects values under the value with a JSONPath expression.
+func (v JSONValue) JSONPath(expr string) (JSONValue, error) {
+	compiled, err := jsonpath.Compile(expr)
+	if err != nil {
+		return JSONValue{}, fmt.Errorf("invalid jsonpath %q, reason: %v", expr, err)
+	}
+
+	value, err := compiled.Lookup(v.value)
+	if err != nil {
+		return JSONValue{}, fmt.Errorf("lookup jsonpath %q failed, reason: %v", expr, err)
+	}
+	return JSONValue{value}, nil
+}
+
+// Get returns a field of the value if it is an object.
+func (v JSONValue) Get(key string) JSONValue {
+	object, _ := v.value.(map[string]interface{})
+	return JSONValue{object[key]}
+}
+
+// Attr returns the text of a field of the value if it is an object.
+func (v JSONValue) Attr(key string) string {
+	return v.Get(key).Text()
+}
+
+// Array returns elements of the value if it is an array.
+func (v JSONValue) Array() []JSONValue {
+	array, _ := v.value.([]interface{})
+	values := make([]JSONValue, 0, len(array))
+	for _, value := range array {
+		values = append(values, JSONValue{value})
+	}
+	return values
+}
+
+// Text returns strings and numbers as they are and encodes other values as JSON.
+// A null or missing value results in an empty string.
+func (v JSONValue) Text() string {
+	switch value := v.value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case stdjson.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+}
+
+// Texts returns the text of every element if the value is an array, otherwise the
+// text of the value itself.
+func (v JSONValue) Texts() []string {
+	if _, ok := v.value.([]interface{}); !ok {
+		return []string{v.Text()}
+	}
+
+	var texts []string
+	for _, value := range v.Array() {
+		texts = append(texts, value.Text())
+	}
+	return texts
+}
+
+// Interface returns the decoded value.
+func (v JSONValue) Interface() interface{} {
+	return v.value
+}
@@ -0,0 +1,138 @@
+package krawler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestDownloadResultHTMLHelpers(t *testing.T) {
+	result := &DownloadResult{Content: []byte(`<html><body>
+<ul id="items"><li class="item" data-id="1">One</li><li class="item" data-id="2">Two</li></ul>
+<p>Price: <b>42</b></p>
+</body></html>`)}
+
+	selection, err := result.Find("li.item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts := selection.Map(func(_ int, s *goquery.Selection) string { return s.Text() }); strings.Join(texts, ",") != "One,Two" {
+		t.Errorf("expect One,Two, got %v", texts)
+	}
+
+	tests := []struct {
+		expr  string
+		attr  string
+		texts string
+		fail  bool
+	}{
+		{"//li[@class='item']", "", "One,Two", false},
+		{"//li[@class='item']", "data-id", "1", false},
+		{"//p/b", "", "42", false},
+		{"//table", "", "", false},
+		{"//li[", "", "", true},
+	}
+	for _, test := range tests {
+		nodes, err := result.XPath(test.expr)
+		if (err != nil) != test.fail {
+			t.Errorf("%s: expect failure %v, got error %v", test.expr, test.fail, err)
+			continue
+		}
+		got := strings.Join(nodes.Texts(), ",")
+		if test.attr != "" {
+			got = nodes.Attr(test.attr)
+		}
+		if got != test.texts {
+			t.Errorf("%s: expect %q, got %q", test.expr, test.texts, got)
+		}
+	}
+
+	// the document is parsed once and shared by the helpers
+	first, _ := result.HTML()
+	result.Content = []byte("<p>changed</p>")
+	if second, _ := result.HTML(); second != first {
+		t.Error("expect the parsed document to be cached")
+	}
+	if nodes, _ := result.XPath("//li"); len(nodes) != 2 {
+		t.Errorf("expect XPath to use the cached document, got %d nodes", len(nodes))
+	}
+}
+
+func TestDownloadResultXMLHelpers(t *testing.T) {
+	result := &DownloadResult{Content: []byte(`<?xml version="1.0"?>
+<urlset><url><loc>http://example.com/a</loc></url><url><loc>http://example.com/b</loc></url></urlset>`)}
+
+	nodes, err := result.XMLPath("//url/loc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts := strings.Join(nodes.Texts(), ","); texts != "http://example.com/a,http://example.com/b" {
+		t.Errorf("expect both locations, got %s", texts)
+	}
+	if _, err := result.XMLPath("//url["); err == nil {
+		t.Error("expect an invalid xpath to fail")
+	}
+}
+
+func TestDownloadResultJSONHelpers(t *testing.T) {
+	result := &DownloadResult{Content: []byte(`{
+		"id": 1234567890123456789,
+		"negative": -9007199254740993,
+		"huge": 123456789012345678901234567890,
+		"small": 42,
+		"price": 10.50,
+		"exp": 1e3,
+		"ok": true,
+		"none": null,
+		"tags": ["a", "b"],
+		"items": [{"name": "cheap", "price": 5}, {"name": "dear", "price": 20}]
+	}`)}
+
+	tests := []struct {
+		expr string
+		text string
+		fail bool
+	}{
+		{"$.id", "1234567890123456789", false},
+		{"$.negative", "-9007199254740993", false},
+		{"$.huge", "123456789012345678901234567890", false},
+		{"$.small", "42", false},
+		{"$.price", "10.5", false},
+		{"$.exp", "1000", false},
+		{"$.ok", "true", false},
+		{"$.none", "", false},
+		{"$.tags", `["a","b"]`, false},
+		{"$.items[?(@.price > 10)].name", `["dear"]`, false},
+		{"$.missing", "", true},
+		{"$.items[", "", true},
+	}
+	for _, test := range tests {
+		value, err := result.JSONPath(test.expr)
+		if (err != nil) != test.fail {
+			t.Errorf("%s: expect failure %v, got error %v", test.expr, test.fail, err)
+			continue
+		}
+		if text := value.Text(); !test.fail && text != test.text {
+			t.Errorf("%s: expect %s, got %s", test.expr, test.text, text)
+		}
+	}
+
+	doc, err := result.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small, ok := doc.Get("small").Interface().(float64); !ok || small != 42 {
+		t.Errorf("expect small integers to be float64, got %#v", doc.Get("small").Interface())
+	}
+	if texts := strings.Join(doc.Get("tags").Texts(), ","); texts != "a,b" {
+		t.Errorf("expect a,b, got %s", texts)
+	}
+	if names := len(doc.Get("items").Array()); names != 2 {
+		t.Errorf("expect 2 items, got %d", names)
+	}
+
+	if _, err := (&DownloadResult{Content: []byte("{")}).JSON(); err == nil {
+		t.Error("expect broken JSON to fail")
+	}
+}
@@ -0,0 +1,357 @@
+package krawler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// requestBody returns the body of a request without consuming it.
+func requestBody(request *http.Request) ([]byte, error) {
+	if request.GetBody == nil {
+		return nil, nil
+	}
+
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("read request body failed, reason: %v", err)
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+func hmacSum(newHash func() hash.Hash, key []byte, data string) []byte {
+	mac := hmac.New(newHash, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SigV4Config defines the structure of SigV4Config
+type SigV4Config struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Service         string
+}
+
+// SigV4Signer signs requests with AWS Signature Version 4.
+type SigV4Signer struct {
+	config SigV4Config
+	now    func() time.Time
+}
+
+// NewSigV4Signer creates a AWS Signature Version 4 signer.
+func NewSigV4Signer(config SigV4Config) *SigV4Signer {
+	return &SigV4Signer{config: config, now: time.Now}
+}
+
+// Authenticate implements Authenticator#Authenticate
+func (s *SigV4Signer) Authenticate(request *http.Request, client *http.Client) error {
+	body, err := requestBody(request)
+	if err != nil {
+		return err
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := strings.Join([]string{now.Format("20060102"), s.config.Region, s.config.Service, "aws4_request"}, "/")
+	payloadHash := sha256Hex(body)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	if s.config.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+	if s.config.Service == "s3" {
+		request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	signedHeaders, canonicalHeaders := sigV4Headers(request)
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		sigV4Path(request.URL, s.config.Service),
+		sigV4Query(request.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := []byte("AWS4" + s.config.SecretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSum(sha256.New, key, part)
+	}
+	signature := hex.EncodeToString(hmacSum(sha256.New, key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// sigV4Escape escapes a string as required by SigV4, which keeps nothing but the
+// unreserved characters of RFC 3986.
+func sigV4Escape(value string) string {
+	builder := new(strings.Builder)
+	for _, b := range []byte(value) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '-' || b == '_' || b == '.' || b == '~' {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+// sigV4Path returns the canonical path of a URL. Segments are split on the path as
+// it is sent, so that an escaped slash stays in its segment. S3 escapes them once,
+// while other services escape the path sent once more.
+func sigV4Path(u *url.URL, service string) string {
+	escaped := u.EscapedPath()
+	if escaped == "" {
+		return "/"
+	}
+
+	segments := strings.Split(escaped, "/")
+	for i, segment := range segments {
+		if service == "s3" {
+			if unescaped, err := url.PathUnescape(segment); err == nil {
+				segment = unescaped
+			}
+		}
+		segments[i] = sigV4Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func sigV4Query(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key)+"="+sigV4Escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Headers returns the signed headers and the canonical headers. The host,
+// the content type and every x-amz-* header are signed.
+func sigV4Headers(request *http.Request) (string, string) {
+	host := request.Host
+	if host == "" {
+		host = request.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for field, values := range request.Header {
+		name := strings.ToLower(field)
+		if name != "content-type" && !strings.HasPrefix(name, "x-amz-") {
+			continue
+		}
+
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonical := new(strings.Builder)
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return strings.Join(names, ";"), canonical.String()
+}
+
+// HMACConfig defines the structure of HMACConfig. The signature is the HMAC of
+// the text rendered from Template, a text/template over the fields Method, Host,
+// Path (escaped), Query (raw), Body, BodySHA256 (hex), Timestamp (formatted with
+// TimestampFormat) and Nonce (random hex) of the request, with a function header
+// returning a request header, like {{header "Date"}}.
+type HMACConfig struct {
+	Key string
+
+	// Algorithm is one of sha1, sha256 and sha512. Default to sha256.
+	Algorithm string
+
+	// Encoding of the signature, either hex or base64. Default to hex.
+	Encoding string
+
+	Template string
+
+	// SignatureHeader is the header carrying the signature, and SignatureFormat is
+	// a template of its value with the fields of Template plus .Signature. Default
+	// to the signature only.
+	SignatureHeader string
+	SignatureFormat string
+
+	// TimestampHeader and NonceHeader send the timestamp and the nonce if not
+	// empty.
+	TimestampHeader string
+	NonceHeader     string
+
+	// TimestampFormat is unix, unix-ms or a time layout. Default to unix.
+	TimestampFormat string
+}
+
+// HMACSigner signs requests with a HMAC over a template of the request.
+type HMACSigner struct {
+	config  HMACConfig
+	hash    func() hash.Hash
+	message *template.Template
+	format  *template.Template
+	now     func() time.Time
+}
+
+// hmacFields are the fields of the templates of HMACSigner.
+type hmacFields struct {
+	Method     string
+	Host       string
+	Path       string
+	Query      string
+	Body       string
+	BodySHA256 string
+	Timestamp  string
+	Nonce      string
+	Signature  string
+}
+
+// NewHMACSigner creates a HMAC signer.
+func NewHMACSigner(config HMACConfig) (*HMACSigner, error) {
+	s := &HMACSigner{config: config, now: time.Now}
+
+	switch strings.ToLower(config.Algorithm) {
+	case "", "sha256":
+		s.hash = sha256.New
+	case "sha1":
+		s.hash = sha1.New
+	case "sha512":
+		s.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unknown hmac algorithm %s", config.Algorithm)
+	}
+
+	switch config.Encoding {
+	case "", "hex", "base64":
+	default:
+		return nil, fmt.Errorf("unknown signature encoding %s", config.Encoding)
+	}
+
+	if config.SignatureHeader == "" {
+		return nil, fmt.Errorf("signature header is not configured")
+	}
+	if config.SignatureFormat == "" {
+		s.config.SignatureFormat = "{{.Signature}}"
+	}
+
+	var err error
+	if s.message, err = parseHMACTemplate("message", config.Template); err != nil {
+		return nil, err
+	}
+	if s.format, err = parseHMACTemplate("signature", s.config.SignatureFormat); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseHMACTemplate(name, text string) (*template.Template, error) {
+	// the header function is replaced per request
+	funcs := template.FuncMap{"header": func(string) string { return "" }}
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template, reason: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+func (s *HMACSigner) timestamp(now time.Time) string {
+	switch s.config.TimestampFormat {
+	case "", "unix":
+		return strconv.FormatInt(now.Unix(), 10)
+	case "unix-ms":
+		return strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	default:
+		return now.UTC().Format(s.config.TimestampFormat)
+	}
+}
+
+// Authenticate implements Authenticator#Authenticate
+func (s *HMACSigner) Authenticate(request *http.Request, client *http.Client) error {
+	body, err := requestBody(request)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce failed, reason: %v", err)
+	}
+
+	fields := &hmacFields{
+		Method:     request.Method,
+		Host:       request.URL.Host,
+		Path:       request.URL.EscapedPath(),
+		Query:      request.URL.RawQuery,
+		Body:       string(body),
+		BodySHA256: sha256Hex(body),
+		Timestamp:  s.timestamp(s.now()),
+		Nonce:      hex.EncodeToString(nonce),
+	}
+	if s.config.TimestampHeader != "" {
+		request.Header.Set(s.config.TimestampHeader, fields.Timestamp)
+	}
+	if s.config.NonceHeader != "" {
+		request.Header.Set(s.config.NonceHeader, fields.Nonce)
+	}
+
+	funcs := template.FuncMap{"header": request.Header.Get}
+	message := new(bytes.Buffer)
+	if err := template.Must(s.message.Clone()).Funcs(funcs).Execute(message, fields); err != nil {
+		return fmt.Errorf("render signed message failed, reason: %v", err)
+	}
+
+	signature := hmacSum(s.hash, []byte(s.config.Key), message.String())
+	if s.config.Encoding == "base64" {
+		fields.Signature = base64.StdEncoding.EncodeToString(signature)
+	} else {
+		fields.Signature = hex.EncodeToString(signature)
+	}
+
+	value := new(bytes.Buffer)
+	if err := template.Must(s.format.Clone()).Funcs(funcs).Execute(value, fields); err != nil {
+		return fmt.Errorf("render signature header failed, reason: %v", err)
+	}
+	request.Header.Set(s.config.SignatureHeader, value.String())
+	return nil
+}
@@ -0,0 +1,129 @@
+package krawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigV4Signer(service string) *SigV4Signer {
+	signer := NewSigV4Signer(SigV4Config{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         service,
+	})
+	signer.now = func() time.Time {
+		return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	}
+	return signer
+}
+
+// TestSigV4Signer checks requests of the AWS Signature Version 4 test suite.
+func TestSigV4Signer(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		signedHeaders string
+		signature     string
+	}{
+		{"get-vanilla", "GET", "https://example.amazonaws.com/", "host;x-amz-date",
+			"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "host;x-amz-date",
+			"b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-unreserved", "GET", "https://example.amazonaws.com/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz", "host;x-amz-date",
+			"07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f"},
+		{"post-vanilla", "POST", "https://example.amazonaws.com/", "host;x-amz-date",
+			"5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+	}
+
+	for _, test := range tests {
+		request, _ := http.NewRequest(test.method, test.url, nil)
+		if err := newTestSigV4Signer("service").Authenticate(request, nil); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		expect := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=%s, Signature=%s",
+			test.signedHeaders, test.signature)
+		if authorization := request.Header.Get("Authorization"); authorization != expect {
+			t.Errorf("%s: expect %s, got %s", test.name, expect, authorization)
+		}
+	}
+}
+
+// TestSigV4SignerIAM checks the example of the AWS documentation.
+func TestSigV4SignerIAM(t *testing.T) {
+	signer := newTestSigV4Signer("iam")
+	request, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	if err := signer.Authenticate(request, nil); err != nil {
+		t.Fatal(err)
+	}
+	expect := "Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if authorization := request.Header.Get("Authorization"); !strings.HasSuffix(authorization, expect) {
+		t.Errorf("expect %s, got %s", expect, authorization)
+	}
+}
+
+func TestSigV4Path(t *testing.T) {
+	tests := []struct {
+		url     string
+		service string
+		path    string
+	}{
+		{"https://example.com", "service", "/"},
+		{"https://example.com/a/b", "service", "/a/b"},
+		{"https://example.com/a b", "service", "/a%2520b"},
+		{"https://example.com/a%2Fb/c", "service", "/a%252Fb/c"},
+		{"https://example.com/ሴ", "service", "/%25E1%2588%25B4"},
+		{"https://example.com/a!b", "service", "/a%21b"},
+		{"https://example.com/a b", "s3", "/a%20b"},
+		{"https://example.com/a%2Fb/c", "s3", "/a%2Fb/c"},
+		{"https://example.com/ሴ", "s3", "/%E1%88%B4"},
+		{"https://example.com/a!b", "s3", "/a%21b"},
+	}
+
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		if path := sigV4Path(u, test.service); path != test.path {
+			t.Errorf("%s for %s: expect %s, got %s", test.url, test.service, test.path, path)
+		}
+	}
+}
+
+func TestHTTPDownloaderSignsRedirects(t *testing.T) {
+	var signatures []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures = append(signatures, r.URL.Path+" "+r.Header.Get("Authorization"))
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	signer := AuthenticatorFunc(func(request *http.Request, client *http.Client) error {
+		request.Header.Set("Authorization", "signed "+request.URL.Path)
+		return nil
+	})
+	downloader := NewHTTPDownloader(GetDefaultConfig(), WithSigner(u.Hostname(), signer))
+	defer downloader.Shutdown()
+
+	ch := make(chan *DownloadResult, 1)
+	downloader.Download(&Task{URL: server.URL + "/start", Method: http.MethodGet}, ch)
+	if result := <-ch; result.Err != nil {
+		t.Fatal(result.Err)
+	}
+
+	expect := []string{"/start signed /start", "/final signed /final"}
+	if strings.Join(signatures, ",") != strings.Join(expect, ",") {
+		t.Errorf("expect %v, got %v", expect, signatures)
+	}
+}
@@ -0,0 +1,68 @@
+package krawler
+
+import (
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the counters of an engine.
+type Stats struct {
+	// Running is the number of tasks being downloaded, processed or postponed.
+	Running int64
+
+	Downloaded     int64
+	DownloadFailed int64
+	Processed      int64
+	ProcessFailed  int64
+	Retried        int64
+	Postponed      int64
+	Dropped        int64
+
+	// Timings are the average timings of requests reported by the downloader.
+	Timings TimingStats
+
+	// Breakers are the states of circuits that are not closed, keyed by host.
+	Breakers map[string]BreakerState
+}
+
+// StatsReporter is implemented by downloaders and middlewares that add their own
+// information into the stats of the engine.
+type StatsReporter interface {
+	ReportStats(stats *Stats)
+}
+
+// engineCounters are the counters of an engine, updated atomically.
+type engineCounters struct {
+	downloaded     int64
+	downloadFailed int64
+	processed      int64
+	processFailed  int64
+	retried        int64
+	postponed      int64
+	dropped        int64
+}
+
+// Stats returns a snapshot of the counters of the engine.
+func (e *Engine) Stats() Stats {
+	stats := Stats{
+		Running:        atomic.LoadInt64(e.downloadingCount),
+		Downloaded:     atomic.LoadInt64(&e.counters.downloaded),
+		DownloadFailed: atomic.LoadInt64(&e.counters.downloadFailed),
+		Processed:      atomic.LoadInt64(&e.counters.processed),
+		ProcessFailed:  atomic.LoadInt64(&e.counters.processFailed),
+		Retried:        atomic.LoadInt64(&e.counters.retried),
+		Postponed:      atomic.LoadInt64(&e.counters.postponed),
+		Dropped:        atomic.LoadInt64(&e.counters.dropped),
+		Breakers:       make(map[string]BreakerState),
+	}
+
+	if reporter, ok := e.downloader.(StatsReporter); ok {
+		reporter.ReportStats(&stats)
+	}
+	for _, middleware := range e.middlewares {
+		if reporter, ok := middleware.(StatsReporter); ok {
+			reporter.ReportStats(&stats)
+		}
+	}
+
+	return stats
+}
@@ -3,6 +3,7 @@ package krawler
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -21,6 +22,11 @@ type Task struct {
 	// Cookies is a slice of cookies that sent along with the request.
 	Cookies []*http.Cookie
 
+	// Session names the cookie session of the task. If the downloader has a cookie
+	// jar, cookies set by responses are stored in the session and sent along with
+	// later tasks of the same session.
+	Session string
+
 	// Body is the body of a http request.
 	Body []byte
 
@@ -35,13 +41,25 @@ type Task struct {
 	// If true, task would not be retried if processor failed.
 	DontRetryIfProcessorFails bool
 
+	// RecrawlInterval is how long the task stays a duplicate after it is enqueued.
+	// Zero means forever, unless the processor of the task has a recrawl interval.
+	RecrawlInterval time.Duration
+
+	// Timeouts overrides the timeouts of the downloader for this task.
+	Timeouts Timeouts
+
 	// Meta is the meta information of a task.
 	Meta Meta
 }
 
-// HashCode returns a unique identity to the task.
+// HashCode returns a unique identity to the task. If the task has a body, a
+// fingerprint of the normalized body is included, so that different bodies sent
+// to the same URL are different tasks.
 func (t *Task) HashCode() string {
-	return fmt.Sprintf("%s|%s|%s", t.Method, t.URL, t.ProcessorName)
+	if len(t.Body) == 0 {
+		return fmt.Sprintf("%s|%s|%s", t.Method, t.URL, t.ProcessorName)
+	}
+	return fmt.Sprintf("%s|%s|%s|%s", t.Method, t.URL, t.ProcessorName, t.bodyFingerprint())
 }
 
 // Name returns name of the task
@@ -55,4 +73,69 @@ type Meta struct {
 	DownloadStartTime  time.Time
 	DownloadFinishTime time.Time
 	RetryTimes         int
+
+	// Proxy is the proxy used by the last download attempt, with its password
+	// redacted. A retry avoids it if other proxies are available.
+	Proxy string
+}
+
+// Timeouts defines the time limits of the phases of a download. A zero Connect,
+// TLSHandshake or ResponseHeader means no limit for the phase, while Total always
+// applies.
+type Timeouts struct {
+	// Connect limits the time to establish a TCP connection.
+	Connect time.Duration
+
+	// TLSHandshake limits the time to complete a TLS handshake.
+	TLSHandshake time.Duration
+
+	// ResponseHeader limits the time to wait for the response after the request is written.
+	ResponseHeader time.Duration
+
+	// Total limits the whole download, including reading the body.
+	Total time.Duration
+}
+
+// merge returns the timeouts overridden by the non-zero fields of overrides.
+func (t Timeouts) merge(overrides Timeouts) Timeouts {
+	if overrides.Connect > 0 {
+		t.Connect = overrides.Connect
+	}
+	if overrides.TLSHandshake > 0 {
+		t.TLSHandshake = overrides.TLSHandshake
+	}
+	if overrides.ResponseHeader > 0 {
+		t.ResponseHeader = overrides.ResponseHeader
+	}
+	if overrides.Total > 0 {
+		t.Total = overrides.Total
+	}
+	return t
+}
+
+// DedupByQueryParams returns a dedup key function for ProcessorOptions, which
+// identifies a task by its method, processor and URL keeping only the query
+// parameters named by params. The fragment is dropped, and so is any other query
+// parameter such as a session id or a sort order.
+func DedupByQueryParams(params ...string) func(task *Task) string {
+	return func(task *Task) string {
+		u, err := url.Parse(task.URL)
+		if err != nil {
+			return task.HashCode()
+		}
+
+		query := u.Query()
+		kept := make(url.Values)
+		for _, param := range params {
+			if values, exists := query[param]; exists {
+				kept[param] = values
+			}
+		}
+		u.RawQuery = kept.Encode()
+		u.Fragment = ""
+
+		keyTask := *task
+		keyTask.URL = u.String()
+		return keyTask.HashCode()
+	}
 }
@@ -0,0 +1,173 @@
+package krawler
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+
+	json "github.com/json-iterator/go"
+)
+
+// MultipartFile is a file of a multipart body.
+type MultipartFile struct {
+	FieldName   string
+	FileName    string
+	ContentType string
+	Content     []byte
+}
+
+// NewFormTask creates a task posting a form.
+func NewFormTask(url string, form url.Values, processorName string) *Task {
+	task := &Task{URL: url, ProcessorName: processorName}
+	task.SetFormBody(form)
+	return task
+}
+
+// NewJSONTask creates a task posting value encoded as JSON.
+func NewJSONTask(url string, value interface{}, processorName string) (*Task, error) {
+	task := &Task{URL: url, ProcessorName: processorName}
+	if err := task.SetJSONBody(value); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// NewGraphQLTask creates a task posting a GraphQL query.
+func NewGraphQLTask(url string, query string, variables map[string]interface{}, processorName string) (*Task, error) {
+	task := &Task{URL: url, ProcessorName: processorName}
+	if err := task.SetGraphQLBody(query, variables); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// setBody sets the body and the content type of the task. A task without a method
+// or with GET becomes a POST.
+func (t *Task) setBody(body []byte, contentType string) {
+	if t.Method == "" || t.Method == http.MethodGet {
+		t.Method = http.MethodPost
+	}
+	if t.Headers == nil {
+		t.Headers = make(http.Header)
+	}
+	t.Headers.Set("Content-Type", contentType)
+	t.Body = body
+}
+
+// SetFormBody sets a form-encoded body.
+func (t *Task) SetFormBody(form url.Values) {
+	t.setBody([]byte(form.Encode()), "application/x-www-form-urlencoded")
+}
+
+// SetJSONBody sets value encoded as JSON as the body. Keys of maps are sorted, so
+// that the same value always produces the same body.
+func (t *Task) SetJSONBody(value interface{}) error {
+	body, err := json.ConfigCompatibleWithStandardLibrary.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("marshal json body failed, reason: %v", err)
+	}
+
+	t.setBody(body, "application/json")
+	return nil
+}
+
+// SetGraphQLBody sets a GraphQL query with its variables as the body.
+func (t *Task) SetGraphQLBody(query string, variables map[string]interface{}) error {
+	body := map[string]interface{}{"query": query}
+	if len(variables) > 0 {
+		body["variables"] = variables
+	}
+	return t.SetJSONBody(body)
+}
+
+// SetMultipartBody sets a multipart/form-data body of fields and files. The
+// boundary is derived from the content, so that the same fields and files always
+// produce the same body.
+func (t *Task) SetMultipartBody(fields url.Values, files ...MultipartFile) error {
+	hash := sha1.New()
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%q=%q;", key, fields[key])
+	}
+	for _, file := range files {
+		fmt.Fprintf(hash, "%q;%q;%q;", file.FieldName, file.FileName, file.ContentType)
+		hash.Write(file.Content)
+	}
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	if err := writer.SetBoundary("krawler" + hex.EncodeToString(hash.Sum(nil))); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		for _, value := range fields[key] {
+			if err := writer.WriteField(key, value); err != nil {
+				return fmt.Errorf("write multipart field %s failed, reason: %v", key, err)
+			}
+		}
+	}
+	for _, file := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+			"name":     file.FieldName,
+			"filename": file.FileName,
+		}))
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header.Set("Content-Type", contentType)
+
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return fmt.Errorf("write multipart file %s failed, reason: %v", file.FileName, err)
+		}
+		if _, err := part.Write(file.Content); err != nil {
+			return fmt.Errorf("write multipart file %s failed, reason: %v", file.FileName, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	t.setBody(body.Bytes(), writer.FormDataContentType())
+	return nil
+}
+
+// bodyFingerprint returns a hash of the body that ignores differences not changing
+// its meaning, such as the order of form fields and the formatting of JSON.
+func (t *Task) bodyFingerprint() string {
+	body := t.Body
+
+	mediaType, _, _ := mime.ParseMediaType(t.Headers.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			body = []byte(form.Encode())
+		}
+	case "application/json":
+		var value interface{}
+		decoder := json.ConfigCompatibleWithStandardLibrary.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err == nil {
+			if normalized, err := json.ConfigCompatibleWithStandardLibrary.Marshal(value); err == nil {
+				body = normalized
+			}
+		}
+	}
+
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
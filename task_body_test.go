@@ -0,0 +1,164 @@
+package krawler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestTaskBodies(t *testing.T) {
+	form := url.Values{"b": {"2"}, "a": {"1", "x y"}}
+	formTask := NewFormTask("http://example.com/form", form, "page")
+	jsonTask, err := NewJSONTask("http://example.com/json", map[string]interface{}{"b": 2, "a": []int{1}}, "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	graphQLTask, err := NewGraphQLTask("http://example.com/graphql", "{ items { id } }", nil, "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	variablesTask, err := NewGraphQLTask("http://example.com/graphql", "query($id: ID!) { item(id: $id) { id } }", map[string]interface{}{"id": "1"}, "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTask := &Task{URL: "http://example.com/json", Method: http.MethodPut, Headers: http.Header{"X-Token": {"t"}}}
+	if err := putTask.SetJSONBody([]string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		task        *Task
+		method      string
+		contentType string
+		body        string
+	}{
+		{formTask, http.MethodPost, "application/x-www-form-urlencoded", "a=1&a=x+y&b=2"},
+		{jsonTask, http.MethodPost, "application/json", `{"a":[1],"b":2}`},
+		{graphQLTask, http.MethodPost, "application/json", `{"query":"{ items { id } }"}`},
+		{variablesTask, http.MethodPost, "application/json", `{"query":"query($id: ID!) { item(id: $id) { id } }","variables":{"id":"1"}}`},
+		{putTask, http.MethodPut, "application/json", `["a"]`},
+	}
+
+	for _, test := range tests {
+		task := test.task
+		if task.Method != test.method || task.Headers.Get("Content-Type") != test.contentType || string(task.Body) != test.body {
+			t.Errorf("%s: expect %s %s %s, got %s %s %s", task.URL, test.method, test.contentType, test.body,
+				task.Method, task.Headers.Get("Content-Type"), task.Body)
+		}
+	}
+	if putTask.Headers.Get("X-Token") != "t" {
+		t.Errorf("expect headers of the task to be kept, got %v", putTask.Headers)
+	}
+
+	if _, err := NewJSONTask("http://example.com/json", func() {}, "page"); err == nil {
+		t.Error("expect an error for a value that cannot be marshalled")
+	}
+}
+
+func TestTaskMultipartBody(t *testing.T) {
+	fields := url.Values{"title": {"report"}, "tags": {"a", "b"}}
+	files := []MultipartFile{
+		{FieldName: "file", FileName: "report.csv", ContentType: "text/csv", Content: []byte("a,b\n1,2\n")},
+		{FieldName: "raw", FileName: "raw.bin", Content: []byte{0, 1, 2}},
+	}
+
+	newTask := func(files ...MultipartFile) *Task {
+		task := &Task{URL: "http://example.com/upload"}
+		if err := task.SetMultipartBody(fields, files...); err != nil {
+			t.Fatal(err)
+		}
+		return task
+	}
+
+	task := newTask(files...)
+	if task.Method != http.MethodPost {
+		t.Errorf("expect POST, got %s", task.Method)
+	}
+	if again := newTask(files...); !bytes.Equal(task.Body, again.Body) || task.HashCode() != again.HashCode() {
+		t.Error("expect the same fields and files to produce the same body")
+	}
+	if other := newTask(files[0]); task.HashCode() == other.HashCode() {
+		t.Error("expect different files to produce different bodies")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(task.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("expect a multipart content type, got %s", task.Headers.Get("Content-Type"))
+	}
+	form, err := multipart.NewReader(bytes.NewReader(task.Body), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer form.RemoveAll()
+
+	if len(form.Value["tags"]) != 2 || form.Value["tags"][1] != "b" || form.Value["title"][0] != "report" {
+		t.Errorf("expect the fields to be encoded, got %v", form.Value)
+	}
+	for _, file := range files {
+		headers := form.File[file.FieldName]
+		if len(headers) != 1 || headers[0].Filename != file.FileName {
+			t.Errorf("%s: expect file %s, got %v", file.FieldName, file.FileName, headers)
+			continue
+		}
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		if headers[0].Header.Get("Content-Type") != contentType {
+			t.Errorf("%s: expect content type %s, got %s", file.FieldName, contentType, headers[0].Header.Get("Content-Type"))
+		}
+		opened, err := headers[0].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(opened)
+		opened.Close()
+		if !bytes.Equal(content, file.Content) {
+			t.Errorf("%s: expect content %q, got %q", file.FieldName, file.Content, content)
+		}
+	}
+}
+
+func TestTaskBodyFingerprint(t *testing.T) {
+	const (
+		form    = "application/x-www-form-urlencoded"
+		jsonTyp = "application/json; charset=utf-8"
+		text    = "text/plain"
+	)
+
+	tests := []struct {
+		contentType string
+		body        string
+		other       string
+		same        bool
+	}{
+		{form, "a=1&b=2", "b=2&a=1", true},
+		{form, "a=1&a=2", "a=2&a=1", false},
+		{form, "a=x+y", "a=x%20y", true},
+		{form, "a=1", "a=2", false},
+		{jsonTyp, `{"a": 1, "b": [1, 2]}`, `{"b":[1,2],"a":1}`, true},
+		{jsonTyp, `{"a": [1, 2]}`, `{"a": [2, 1]}`, false},
+		{jsonTyp, `{"id": 12345678901234567890}`, `{"id": 12345678901234567891}`, false},
+		{jsonTyp, `{"a": 1}`, `{"a": "1"}`, false},
+		{jsonTyp, `{"a": 1`, `{"a":1`, false},
+		{text, "a=1&b=2", "b=2&a=1", false},
+		{text, "same", "same", true},
+	}
+
+	for _, test := range tests {
+		task := &Task{URL: "http://example.com/", Method: http.MethodPost, Headers: http.Header{"Content-Type": {test.contentType}}, Body: []byte(test.body)}
+		other := &Task{URL: "http://example.com/", Method: http.MethodPost, Headers: http.Header{"Content-Type": {test.contentType}}, Body: []byte(test.other)}
+		if same := task.HashCode() == other.HashCode(); same != test.same {
+			t.Errorf("%s %s and %s: expect same %v, got %v", test.contentType, test.body, test.other, test.same, same)
+		}
+	}
+
+	withoutBody := &Task{URL: "http://example.com/", Method: http.MethodPost}
+	if withoutBody.HashCode() != "POST|http://example.com/|" {
+		t.Errorf("expect a task without a body to be hashed without fingerprint, got %s", withoutBody.HashCode())
+	}
+}
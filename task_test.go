@@ -0,0 +1,58 @@
+package krawler
+
+import "testing"
+
+func TestDedupByQueryParams(t *testing.T) {
+	dedupKey := DedupByQueryParams("id", "page")
+	tests := []struct {
+		a, b      Task
+		duplicate bool
+	}{
+		{
+			Task{Method: "GET", URL: "http://example.com/item?id=1&sid=abc", ProcessorName: "item"},
+			Task{Method: "GET", URL: "http://example.com/item?sid=def&id=1", ProcessorName: "item"},
+			true,
+		},
+		{
+			Task{Method: "GET", URL: "http://example.com/list?page=2&id=1", ProcessorName: "list"},
+			Task{Method: "GET", URL: "http://example.com/list?id=1&page=2&sort=asc#top", ProcessorName: "list"},
+			true,
+		},
+		{
+			Task{Method: "GET", URL: "http://example.com/item?id=1", ProcessorName: "item"},
+			Task{Method: "GET", URL: "http://example.com/item?id=2", ProcessorName: "item"},
+			false,
+		},
+		{
+			Task{Method: "GET", URL: "http://example.com/item?id=1&id=2", ProcessorName: "item"},
+			Task{Method: "GET", URL: "http://example.com/item?id=1", ProcessorName: "item"},
+			false,
+		},
+		{
+			Task{Method: "GET", URL: "http://example.com/item?id=1", ProcessorName: "item"},
+			Task{Method: "POST", URL: "http://example.com/item?id=1", ProcessorName: "item"},
+			false,
+		},
+		{
+			Task{Method: "GET", URL: "http://example.com/item?id=1", ProcessorName: "item"},
+			Task{Method: "GET", URL: "http://example.com/item?id=1", ProcessorName: "detail"},
+			false,
+		},
+		{
+			Task{Method: "GET", URL: "http://example.com/item", ProcessorName: "item"},
+			Task{Method: "GET", URL: "http://example.com/item?sid=abc", ProcessorName: "item"},
+			true,
+		},
+		{
+			Task{Method: "GET", URL: "http://example.com/%zz?id=1", ProcessorName: "item"},
+			Task{Method: "GET", URL: "http://example.com/%zz?id=1&sid=abc", ProcessorName: "item"},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		if duplicate := dedupKey(&test.a) == dedupKey(&test.b); duplicate != test.duplicate {
+			t.Errorf("%s and %s: expect duplicate %v, got %v", test.a.URL, test.b.URL, test.duplicate, duplicate)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package krawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// autoThrottle spaces out requests to every host by a delay that follows the
+// latency of the host. The delay approaches latency / TargetConcurrency, doubles on
+// 429 and 503 responses, and requests are held back until Retry-After passes.
+type autoThrottle struct {
+	mutex sync.Mutex
+	hosts map[string]*hostThrottle
+
+	startDelay        time.Duration
+	minDelay          time.Duration
+	maxDelay          time.Duration
+	targetConcurrency float64
+}
+
+type hostThrottle struct {
+	delay        time.Duration
+	next         time.Time
+	blockedUntil time.Time
+}
+
+func newAutoThrottle(config ThrottleConfig) *autoThrottle {
+	return &autoThrottle{
+		hosts:             make(map[string]*hostThrottle),
+		startDelay:        config.StartDelay,
+		minDelay:          config.MinDelay,
+		maxDelay:          config.MaxDelay,
+		targetConcurrency: config.TargetConcurrency,
+	}
+}
+
+func (t *autoThrottle) host(name string) *hostThrottle {
+	host := t.hosts[name]
+	if host == nil {
+		host = &hostThrottle{delay: t.clamp(t.startDelay)}
+		t.hosts[name] = host
+	}
+	return host
+}
+
+func (t *autoThrottle) clamp(delay time.Duration) time.Duration {
+	if delay < t.minDelay {
+		return t.minDelay
+	}
+	if delay > t.maxDelay {
+		return t.maxDelay
+	}
+	return delay
+}
+
+// reserve reserves a time slot for a request to host and returns how long the
+// request has to wait for it. If the request would wait for longer than the
+// maximum delay, a PostponeError is returned without reserving.
+func (t *autoThrottle) reserve(hostName string) (time.Duration, error) {
+	t.mutex.Lock()
+	host := t.host(hostName)
+	now := time.Now()
+	start := host.next
+	if start.Before(now) {
+		start = now
+	}
+	if host.blockedUntil.After(start) {
+		start = host.blockedUntil
+	}
+	wait := start.Sub(now)
+	if wait > t.maxDelay {
+		t.mutex.Unlock()
+		return 0, &PostponeError{Reason: fmt.Sprintf("host %s is throttled", hostName), Delay: wait}
+	}
+	host.next = start.Add(host.delay)
+	t.mutex.Unlock()
+
+	return wait, nil
+}
+
+// sleep waits for the reserved time slot of a request.
+func (t *autoThrottle) sleep(ctx context.Context, wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ErrDownloaderShuttingDown
+	}
+}
+
+// feedback adjusts the delay of the host of a task by its download result. A 429
+// or 503 response is turned into a PostponeError that counts as a failure. Failed
+// downloads and cache hits leave the delay unchanged.
+func (t *autoThrottle) feedback(result *DownloadResult) {
+	if result.Err != nil || result.CacheHit {
+		return
+	}
+	u, err := url.Parse(result.Task.URL)
+	if err != nil {
+		return
+	}
+	hostName := u.Hostname()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	host := t.host(hostName)
+	if result.StatusCode == http.StatusTooManyRequests || result.StatusCode == http.StatusServiceUnavailable {
+		delay := host.delay * 2
+		if delay < t.startDelay {
+			delay = t.startDelay
+		}
+		host.delay = t.clamp(delay)
+
+		retryAfter := parseRetryAfter(result.Headers.Get("Retry-After"), time.Now())
+		if retryAfter <= 0 {
+			retryAfter = host.delay
+		}
+		if blockedUntil := time.Now().Add(retryAfter); blockedUntil.After(host.blockedUntil) {
+			host.blockedUntil = blockedUntil
+		}
+
+		log.Warnf("Host %s responds %d, slow down to %v per request and pause for %v", hostName, result.StatusCode, host.delay, retryAfter)
+		result.Err = &PostponeError{
+			Reason:  fmt.Sprintf("host %s responds %d", hostName, result.StatusCode),
+			Delay:   retryAfter,
+			Failure: true,
+		}
+		return
+	}
+
+	latency := result.Task.Meta.DownloadFinishTime.Sub(result.Task.Meta.DownloadStartTime)
+	target := time.Duration(float64(latency) / t.targetConcurrency)
+	delay := (host.delay + target) / 2
+
+	// error responses are usually fast, they should not speed up the crawl
+	if result.StatusCode >= 400 && delay < host.delay {
+		return
+	}
+	host.delay = t.clamp(delay)
+	log.Debugf("Host %s responds in %v, delay becomes %v", hostName, latency, host.delay)
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of
+// seconds or a HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package krawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestThrottle() *autoThrottle {
+	return newAutoThrottle(ThrottleConfig{
+		Enabled:           true,
+		StartDelay:        time.Second,
+		MinDelay:          100 * time.Millisecond,
+		MaxDelay:          10 * time.Second,
+		TargetConcurrency: 1,
+	})
+}
+
+func throttledResult(statusCode int, latency time.Duration, header http.Header) *DownloadResult {
+	task := &Task{URL: "http://example.com/", Method: http.MethodGet}
+	task.Meta.DownloadStartTime = time.Now()
+	task.Meta.DownloadFinishTime = task.Meta.DownloadStartTime.Add(latency)
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &DownloadResult{Task: task, StatusCode: statusCode, Headers: header}
+}
+
+func TestAutoThrottleFeedback(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		result     *DownloadResult
+		expect     time.Duration
+		postponed  bool
+		retryAfter time.Duration
+	}{
+		{"fast host speeds up", time.Second, throttledResult(200, 200*time.Millisecond, nil), 600 * time.Millisecond, false, 0},
+		{"slow host slows down", time.Second, throttledResult(200, 3*time.Second, nil), 2 * time.Second, false, 0},
+		{"bounded by min delay", 100 * time.Millisecond, throttledResult(200, 0, nil), 100 * time.Millisecond, false, 0},
+		{"bounded by max delay", 10 * time.Second, throttledResult(200, time.Minute, nil), 10 * time.Second, false, 0},
+		{"fast error does not speed up", time.Second, throttledResult(404, 0, nil), time.Second, false, 0},
+		{"slow error slows down", time.Second, throttledResult(500, 3*time.Second, nil), 2 * time.Second, false, 0},
+		{"429 doubles", time.Second, throttledResult(429, 0, nil), 2 * time.Second, true, 2 * time.Second},
+		{"503 doubles from start delay", 100 * time.Millisecond, throttledResult(503, 0, nil), time.Second, true, time.Second},
+		{"429 bounded by max delay", 8 * time.Second, throttledResult(429, 0, nil), 10 * time.Second, true, 10 * time.Second},
+		{"429 with retry-after", time.Second, throttledResult(429, 0, http.Header{"Retry-After": {"30"}}), 2 * time.Second, true, 30 * time.Second},
+		{"cache hit", time.Second, &DownloadResult{Task: &Task{URL: "http://example.com/"}, StatusCode: 503, CacheHit: true}, time.Second, false, 0},
+		{"failed download", time.Second, &DownloadResult{Task: &Task{URL: "http://example.com/"}, Err: ErrDownloadTimeout}, time.Second, false, 0},
+	}
+
+	for _, test := range tests {
+		throttle := newTestThrottle()
+		throttle.host("example.com").delay = test.delay
+
+		throttle.feedback(test.result)
+
+		if delay := throttle.host("example.com").delay; delay != test.expect {
+			t.Errorf("%s: expect delay %v, got %v", test.name, test.expect, delay)
+		}
+		postpone, postponed := test.result.Err.(*PostponeError)
+		if postponed != test.postponed {
+			t.Errorf("%s: expect postponed %v, got error %v", test.name, test.postponed, test.result.Err)
+			continue
+		}
+		if postponed && (!postpone.Failure || postpone.Delay != test.retryAfter) {
+			t.Errorf("%s: expect a failure postponed for %v, got %+v", test.name, test.retryAfter, postpone)
+		}
+	}
+}
+
+func TestAutoThrottleReserve(t *testing.T) {
+	throttle := newTestThrottle()
+
+	if wait, err := throttle.reserve("example.com"); err != nil || wait != 0 {
+		t.Fatalf("first request: expect no wait, got %v %v", wait, err)
+	}
+	if wait, err := throttle.reserve("example.com"); err != nil || wait < 900*time.Millisecond || wait > time.Second {
+		t.Fatalf("second request: expect to wait for the delay, got %v %v", wait, err)
+	}
+	if wait, err := throttle.reserve("example.org"); err != nil || wait != 0 {
+		t.Fatalf("another host: expect no wait, got %v %v", wait, err)
+	}
+
+	throttle.feedback(throttledResult(429, 0, http.Header{"Retry-After": {"60"}}))
+	_, err := throttle.reserve("example.com")
+	if postpone, ok := err.(*PostponeError); !ok || postpone.Failure || postpone.Delay < 59*time.Second {
+		t.Fatalf("blocked host: expect to be postponed for Retry-After, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		expect time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"Wed, 01 May 2019 12:00:30 GMT", 30 * time.Second},
+		{"soon", 0},
+	}
+
+	for _, test := range tests {
+		if retryAfter := parseRetryAfter(test.value, now); retryAfter != test.expect {
+			t.Errorf("%q: expect %v, got %v", test.value, test.expect, retryAfter)
+		}
+	}
+}
+
+func TestHTTPDownloaderThrottleReleasesSlot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	config := GetDefaultConfig()
+	config.Request.Concurrency = 1
+	config.Throttle = ThrottleConfig{Enabled: true, StartDelay: time.Second, MaxDelay: 10 * time.Second, TargetConcurrency: 1}
+	downloader := NewHTTPDownloader(config)
+	defer downloader.Shutdown()
+
+	ch := make(chan *DownloadResult, 3)
+	downloader.Download(&Task{URL: server.URL + "/first", Method: http.MethodGet}, ch)
+	downloader.Download(&Task{URL: server.URL + "/throttled", Method: http.MethodGet}, ch)
+	<-ch
+
+	// another host is not held back by the throttled task
+	other := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	downloader.Download(&Task{URL: other + "/other", Method: http.MethodGet}, ch)
+	if result := <-ch; !strings.HasSuffix(result.Task.URL, "/other") {
+		t.Errorf("expect the task of another host to finish first, got %s", result.Task.URL)
+	}
+	<-ch
+}
@@ -0,0 +1,76 @@
+package krawler
+
+import (
+	"sync"
+	"time"
+)
+
+// Timings is the time spent in every phase of a HTTP request. If redirects are
+// followed, the phases of every request are summed up.
+type Timings struct {
+	// DNS, Connect and TLSHandshake are zero if the connection is reused.
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+
+	// FirstByte is the time from asking for a connection to receiving the first
+	// byte of the response, including connecting and the think-time of the server.
+	FirstByte time.Duration
+
+	// BodyRead is the time spent reading and decompressing the body.
+	BodyRead time.Duration
+
+	// ConnReused indicates the last request is sent on a reused connection, which
+	// had been idle for ConnIdleTime.
+	ConnReused   bool
+	ConnIdleTime time.Duration
+}
+
+// TimingStats are the average timings of the requests sent by a downloader.
+type TimingStats struct {
+	Requests    int64
+	ReusedConns int64
+
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	FirstByte    time.Duration
+	BodyRead     time.Duration
+}
+
+// timingStats sums up the timings of requests.
+type timingStats struct {
+	mutex sync.Mutex
+	sum   TimingStats
+}
+
+func (s *timingStats) add(timings *Timings) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sum.Requests++
+	if timings.ConnReused {
+		s.sum.ReusedConns++
+	}
+	s.sum.DNS += timings.DNS
+	s.sum.Connect += timings.Connect
+	s.sum.TLSHandshake += timings.TLSHandshake
+	s.sum.FirstByte += timings.FirstByte
+	s.sum.BodyRead += timings.BodyRead
+}
+
+// average returns the average timings.
+func (s *timingStats) average() TimingStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	average := s.sum
+	if n := time.Duration(average.Requests); n > 0 {
+		average.DNS /= n
+		average.Connect /= n
+		average.TLSHandshake /= n
+		average.FirstByte /= n
+		average.BodyRead /= n
+	}
+	return average
+}
@@ -0,0 +1,98 @@
+package krawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimingStats(t *testing.T) {
+	stats := new(timingStats)
+	if average := stats.average(); average != (TimingStats{}) {
+		t.Errorf("expect empty stats, got %+v", average)
+	}
+
+	stats.add(&Timings{DNS: 2, Connect: 4, TLSHandshake: 6, FirstByte: 10, BodyRead: 20})
+	stats.add(&Timings{FirstByte: 20, BodyRead: 40, ConnReused: true, ConnIdleTime: time.Second})
+
+	expected := TimingStats{
+		Requests:     2,
+		ReusedConns:  1,
+		DNS:          1,
+		Connect:      2,
+		TLSHandshake: 3,
+		FirstByte:    15,
+		BodyRead:     30,
+	}
+	if average := stats.average(); average != expected {
+		t.Errorf("expect %+v, got %+v", expected, average)
+	}
+}
+
+func TestHTTPDownloaderTimings(t *testing.T) {
+	delay := 20 * time.Millisecond
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		time.Sleep(delay)
+		w.Write([]byte("head"))
+		w.(http.Flusher).Flush()
+		time.Sleep(delay)
+		w.Write([]byte("tail"))
+	}))
+	defer server.Close()
+
+	config := GetDefaultConfig()
+	config.Transport.InsecureSkipVerify = true
+	config.Transport.DisableHTTP2 = true
+	downloader := NewHTTPDownloader(config)
+	defer downloader.Shutdown()
+
+	tests := []struct {
+		path         string
+		connReused   bool
+		minFirstByte time.Duration
+	}{
+		{"/", false, delay},
+		{"/", true, delay},
+		{"/redirect", true, delay},
+	}
+
+	for i, test := range tests {
+		ch := make(chan *DownloadResult, 1)
+		downloader.Download(&Task{URL: server.URL + test.path, Method: http.MethodGet}, ch)
+		result := <-ch
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+
+		timings := result.Timings
+		if timings.ConnReused != test.connReused {
+			t.Errorf("request %d: expect connection reused %v, got %v", i, test.connReused, timings.ConnReused)
+		}
+		if fresh := timings.Connect > 0 && timings.TLSHandshake > 0; fresh == test.connReused {
+			t.Errorf("request %d: expect connecting and handshaking %v, got %+v", i, !test.connReused, timings)
+		}
+		if test.connReused && timings.ConnIdleTime <= 0 {
+			t.Errorf("request %d: expect the idle time of the connection, got %v", i, timings.ConnIdleTime)
+		}
+		if timings.FirstByte < test.minFirstByte {
+			t.Errorf("request %d: expect first byte after %v, got %v", i, test.minFirstByte, timings.FirstByte)
+		}
+		if timings.BodyRead < delay {
+			t.Errorf("request %d: expect body read in at least %v, got %v", i, delay, timings.BodyRead)
+		}
+	}
+
+	stats := new(Stats)
+	downloader.ReportStats(stats)
+	if stats.Timings.Requests != 3 || stats.Timings.ReusedConns != 2 {
+		t.Errorf("expect 3 requests with 2 reused connections, got %+v", stats.Timings)
+	}
+	if stats.Timings.FirstByte < delay || stats.Timings.BodyRead < delay {
+		t.Errorf("expect average timings of the requests, got %+v", stats.Timings)
+	}
+}
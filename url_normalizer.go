@@ -0,0 +1,187 @@
+package krawler
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// DefaultTrackingParams are query keys of common tracking parameters. A key ending
+// with * matches every key with the prefix.
+var DefaultTrackingParams = []string{
+	"utm_*", "gclid", "dclid", "fbclid", "msclkid", "yclid", "igshid", "mc_cid", "mc_eid", "_ga", "_gl",
+}
+
+// URLNormalizer canonicalizes URLs so that URLs of the same resource are treated
+// as duplicates. It lowercases the scheme and the host, converts IDN hosts into
+// punycode, removes default ports and fragments, normalizes percent-encoding,
+// strips tracking parameters and sorts the query.
+type URLNormalizer struct {
+	// TrackingParams are query keys stripped from URLs. A key ending with * matches
+	// by prefix.
+	TrackingParams []string
+
+	// IgnoreParams are extra query keys stripped from URLs, like session ids, in the
+	// same form as TrackingParams.
+	IgnoreParams []string
+
+	// KeepFragment keeps fragments, for sites routing pages by fragment.
+	KeepFragment bool
+}
+
+// NewURLNormalizer creates a normalizer stripping DefaultTrackingParams and
+// ignoreParams.
+func NewURLNormalizer(ignoreParams ...string) *URLNormalizer {
+	return &URLNormalizer{
+		TrackingParams: DefaultTrackingParams,
+		IgnoreParams:   ignoreParams,
+	}
+}
+
+// Normalize returns the canonical form of rawURL.
+func (n *URLNormalizer) Normalize(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", fmt.Errorf("invalid url %s, reason: %v", rawURL, err)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Host != "" {
+		host, port := u.Hostname(), u.Port()
+		if host, err = idna.ToASCII(strings.ToLower(host)); err != nil {
+			return "", fmt.Errorf("invalid host of url %s, reason: %v", rawURL, err)
+		}
+		host = strings.TrimSuffix(host, ".")
+		if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+			port = ""
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		u.Host = host
+	}
+
+	path := normalizePercentEncoding(u.EscapedPath())
+	if path == "" && u.Host != "" {
+		path = "/"
+	}
+	normalized := &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Opaque: u.Opaque}
+	if err := setEscapedPath(normalized, path); err != nil {
+		return "", fmt.Errorf("invalid path of url %s, reason: %v", rawURL, err)
+	}
+	normalized.RawQuery = n.normalizeQuery(u.RawQuery)
+	if n.KeepFragment {
+		normalized.Fragment = u.Fragment
+	}
+
+	return normalized.String(), nil
+}
+
+func setEscapedPath(u *url.URL, escaped string) error {
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		return err
+	}
+	u.Path = path
+	u.RawPath = escaped
+	return nil
+}
+
+// normalizeQuery sorts the query and strips tracking and ignored parameters.
+func (n *URLNormalizer) normalizeQuery(rawQuery string) string {
+	var pairs []string
+	for _, pair := range strings.FieldsFunc(rawQuery, func(r rune) bool { return r == '&' || r == ';' }) {
+		key, value := pair, ""
+		hasValue := false
+		if i := strings.Index(pair, "="); i >= 0 {
+			key, value, hasValue = pair[:i], pair[i+1:], true
+		}
+
+		if unescaped, err := url.QueryUnescape(key); err == nil && n.stripped(unescaped) {
+			continue
+		}
+
+		key = normalizePercentEncoding(strings.Replace(key, "%20", "+", -1))
+		if hasValue {
+			pairs = append(pairs, key+"="+normalizePercentEncoding(strings.Replace(value, "%20", "+", -1)))
+		} else {
+			pairs = append(pairs, key)
+		}
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func (n *URLNormalizer) stripped(key string) bool {
+	key = strings.ToLower(key)
+	for _, list := range [][]string{n.TrackingParams, n.IgnoreParams} {
+		for _, pattern := range list {
+			pattern = strings.ToLower(pattern)
+			if strings.HasSuffix(pattern, "*") {
+				if strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+					return true
+				}
+			} else if key == pattern {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isUnreserved tells whether a byte is an unreserved character of RFC 3986.
+func isUnreserved(b byte) bool {
+	return 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '-' || b == '.' || b == '_' || b == '~'
+}
+
+// normalizePercentEncoding decodes escaped unreserved characters, uppercases the
+// remaining escapes and escapes bytes that are not allowed in URLs.
+func normalizePercentEncoding(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	builder := new(strings.Builder)
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(decoded) {
+				builder.WriteByte(decoded)
+			} else {
+				builder.WriteByte('%')
+				builder.WriteByte(hexDigits[decoded>>4])
+				builder.WriteByte(hexDigits[decoded&15])
+			}
+			i += 2
+			continue
+		}
+		if b <= ' ' || b >= 0x7f || b == '%' || b == '"' || b == '<' || b == '>' || b == '\\' || b == '^' || b == '`' || b == '{' || b == '|' || b == '}' {
+			builder.WriteByte('%')
+			builder.WriteByte(hexDigits[b>>4])
+			builder.WriteByte(hexDigits[b&15])
+			continue
+		}
+		builder.WriteByte(b)
+	}
+	return builder.String()
+}
+
+func isHex(b byte) bool {
+	return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+}
+
+func unhex(b byte) byte {
+	switch {
+	case '0' <= b && b <= '9':
+		return b - '0'
+	case 'a' <= b && b <= 'f':
+		return b - 'a' + 10
+	default:
+		return b - 'A' + 10
+	}
+}